@@ -1,70 +1,185 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
-	"time"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 
+	"github.com/Dimashey/blockchain/chaincfg"
 	"github.com/Dimashey/blockchain/internal/util"
+	"github.com/Dimashey/blockchain/wallet"
 )
 
+// BlockVersion is version of block header format
+const BlockVersion = 1
+
+// BlockHeader is the only input of the proof-of-work hash,
+// transactions are committed to it through the merkle root
+type BlockHeader struct {
+	Version    uint32
+	PrevHash   []byte
+	MerkleRoot []byte
+	// WitnessRoot commits to unlocking scripts, which transaction IDs leave out
+	WitnessRoot []byte
+	Timestamp   int64
+	// Bits is compact representation of the target block hash should be below
+	Bits uint32
+	// Nonce is value used to calucalte hash to PoW paradigm
+	Nonce  int64
+	Height int
+	// Seal is engine specific proof that block may be added, e.g. signature of
+	// proof-of-authority signer. It is empty for proof-of-work blocks
+	Seal []byte
+}
+
+// Serialize returns canonical encoding of header, see encoding.go
+func (h *BlockHeader) Serialize() []byte {
+	var e encoder
+
+	h.encode(&e)
+
+	return e.buf.Bytes()
+}
+
+// Hash returns block hash, which is sha256 of the whole header including seal
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// SealHash returns hash of header without seal, it is what seal commits to
+func (h *BlockHeader) SealHash() []byte {
+	header := *h
+	header.Seal = nil
+
+	return header.Hash()
+}
+
 type Block struct {
-	Timestamp    int64
+	BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	// Nonce is value used to calucalte hash to PoW paradigm
-	Nonce  int
-	Height int
 }
 
+// Serialize returns canonical encoding of block, see encoding.go
 func (b *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
+	var e encoder
 
-	err := encoder.Encode(b)
+	b.encode(&e)
 
-	util.HandleError(err)
+	return e.buf.Bytes()
+}
 
-	return res.Bytes()
+// MerkleTree builds merkle tree over transaction IDs of the block
+func (b *Block) MerkleTree() *MerkleTree {
+	var txIDs [][]byte
+
+	for _, tx := range b.Transactions {
+		txIDs = append(txIDs, tx.ID)
+	}
+
+	return NewMerkleTree(txIDs)
 }
 
 func (b *Block) HashTransactions() []byte {
-	var txHashes [][]byte
+	return b.MerkleTree().RootNode.Data
+}
+
+// HashWitnesses returns merkle root of transaction witness hashes
+func (b *Block) HashWitnesses() []byte {
+	var hashes [][]byte
 
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.Serialize())
+		hashes = append(hashes, tx.WitnessHash())
 	}
 
-	tree := NewMerkeTree(txHashes)
+	return NewMerkleTree(hashes).RootNode.Data
+}
+
+// NewBlock assembles block which still has to be sealed by consensus engine
+func NewBlock(txs []*Transaction, prevHash []byte, height int, bits uint32, timestamp int64) *Block {
+	block := &Block{Transactions: txs}
+	block.BlockHeader = BlockHeader{
+		Version:   BlockVersion,
+		PrevHash:  prevHash,
+		Timestamp: timestamp,
+		Bits:      bits,
+		Height:    height,
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.WitnessRoot = block.HashWitnesses()
 
-	return tree.RootNode.Data
+	return block
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), []byte{}, txs, prevHash, 0, height}
+func CreateBlock(engine ConsensusEngine, txs []*Transaction, prevHash []byte, height int, bits uint32, timestamp int64) *Block {
+	block := NewBlock(txs, prevHash, height, bits, timestamp)
 
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
+	err := engine.Seal(context.Background(), block)
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	util.HandleError(err)
 
 	return block
 }
 
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
-}
+// GenesisBlock returns the first block of the active network. It depends on chain parameters,
+// genesis file and consensus config only, so nodes created independently share it and can sync.
+// Without genesis file subsidy of the block is burnt and tokens are only issued by later blocks,
+// with it tokens are premined to its allocations, which are spendable at once.
+// Engines other than proof-of-work are committed to in the Seal, see GenesisConsensus
+func GenesisBlock(genesis *chaincfg.Genesis, config ConsensusConfig) (*Block, error) {
+	params := chaincfg.Active
+	coinbase := Transaction{Inputs: []TxInput{{[]byte{}, -1, []byte(params.GenesisData), MaxSequence}}}
+	premined := genesis != nil && len(genesis.Alloc) > 0
 
-func Deserialize(data []byte) *Block {
-	var block Block
+	if premined {
+		total := 0
+
+		for _, alloc := range genesis.Alloc {
+			if !wallet.ValidateAddress(alloc.Address) {
+				return nil, fmt.Errorf("genesis allocation address %s is not valid", alloc.Address)
+			}
+
+			var ok bool
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
+			if total, ok = addMoney(total, alloc.Amount); !ok {
+				return nil, fmt.Errorf("genesis allocations are negative or more than %d", MaxMoney())
+			}
 
-	err := decoder.Decode(&block)
+			coinbase.Outputs = append(coinbase.Outputs, *NewTXOutput(alloc.Amount, alloc.Address))
+		}
+	} else {
+		script, err := DataScript([]byte(params.GenesisData))
+
+		if err != nil {
+			return nil, err
+		}
+
+		coinbase.Outputs = append(coinbase.Outputs, TxOutput{Subsidy(0), script})
+	}
+
+	coinbase.ID = coinbase.Hash()
+
+	block := NewBlock([]*Transaction{&coinbase}, []byte{}, 0, InitialBits(), params.GenesisTimestamp)
+	block.Seal = config.genesisSeal()
+
+	if err := sealGenesis(block); err != nil {
+		return nil, err
+	}
+
+	if !premined && len(block.Seal) == 0 && hex.EncodeToString(block.Hash) != params.GenesisHash {
+		return nil, fmt.Errorf("genesis block %x of %s does not match %s", block.Hash, params.Name, params.GenesisHash)
+	}
+
+	return block, nil
+}
+
+func Deserialize(data []byte) *Block {
+	block, err := DecodeBlock(data)
 
 	util.HandleError(err)
 
-	return &block
+	return block
 }
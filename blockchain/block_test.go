@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func testHeader() BlockHeader {
+	return BlockHeader{
+		Version:     BlockVersion,
+		PrevHash:    []byte{0x01},
+		MerkleRoot:  []byte{0x02},
+		WitnessRoot: []byte{0x03},
+		Timestamp:   1704067200,
+		Bits:        0x1d00ffff,
+		Nonce:       7,
+		Height:      3,
+		Seal:        []byte{0x04},
+	}
+}
+
+// TestHeaderHash changes every header field and checks that block hash commits to it
+func TestHeaderHash(t *testing.T) {
+	header := testHeader()
+	hash, sealHash := header.Hash(), header.SealHash()
+
+	changes := []struct {
+		field  string
+		change func(h *BlockHeader)
+		sealed bool
+	}{
+		{"PrevHash", func(h *BlockHeader) { h.PrevHash = []byte{0x11} }, true},
+		{"MerkleRoot", func(h *BlockHeader) { h.MerkleRoot = []byte{0x12} }, true},
+		{"WitnessRoot", func(h *BlockHeader) { h.WitnessRoot = []byte{0x13} }, true},
+		{"Timestamp", func(h *BlockHeader) { h.Timestamp++ }, true},
+		{"Bits", func(h *BlockHeader) { h.Bits++ }, true},
+		{"Nonce", func(h *BlockHeader) { h.Nonce++ }, true},
+		{"Height", func(h *BlockHeader) { h.Height++ }, true},
+		{"Seal", func(h *BlockHeader) { h.Seal = []byte{0x14} }, false},
+	}
+
+	for _, c := range changes {
+		changed := testHeader()
+		c.change(&changed)
+
+		if bytes.Compare(changed.Hash(), hash) == 0 {
+			t.Errorf("block hash does not commit to %s", c.field)
+		}
+
+		if sealed := bytes.Compare(changed.SealHash(), sealHash) != 0; sealed != c.sealed {
+			t.Errorf("seal hash commits to %s: %t, want %t", c.field, sealed, c.sealed)
+		}
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	useRegTest(t)
+
+	chain := newTestChain(t, "header", wallet.MakeWallet(), 100)
+	block := buildBlock(t, chain, genesisBlock(t, chain), walletAddress(wallet.MakeWallet()), 0)
+
+	decoded, err := DecodeBlock(block.Serialize())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(decoded.Hash, block.Hash) != 0 || bytes.Compare(decoded.Hash, block.BlockHeader.Hash()) != 0 {
+		t.Errorf("decoded block hash is %x, want %x", decoded.Hash, block.Hash)
+	}
+
+	if bytes.Compare(decoded.Serialize(), block.Serialize()) != 0 {
+		t.Errorf("decoded block %x does not match", decoded.Serialize())
+	}
+
+	if err := ValidateBlock(chain.Engine, decoded); err != nil {
+		t.Errorf("decoded block is not valid: %s", err)
+	}
+
+	// transactions are committed to the header by merkle root only
+	decoded.Transactions[0].Outputs[0].Value++
+	decoded.Transactions[0].ID = decoded.Transactions[0].Hash()
+
+	if err := ValidateBlock(chain.Engine, decoded); !rejected(err, RejectBadMerkleRoot) {
+		t.Errorf("block with changed transaction is validated with error %v, want %s", err, RejectBadMerkleRoot)
+	}
+}
+
+// TestGenesisBlock builds genesis of every network, which has to be the same on every node
+func TestGenesisBlock(t *testing.T) {
+	previous := chaincfg.Active
+	t.Cleanup(func() { chaincfg.Active = previous })
+
+	for _, params := range chaincfg.Networks {
+		chaincfg.Active = params
+
+		genesis, err := GenesisBlock(nil, ConsensusConfig{Engine: PoWEngine})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(genesis.Hash) != params.GenesisHash {
+			t.Errorf("%s genesis is %x, want %s", params.Name, genesis.Hash, params.GenesisHash)
+		}
+
+		if err := (&ProofOfWorkEngine{}).VerifySeal(genesis); err != nil {
+			t.Errorf("%s genesis is not sealed: %s", params.Name, err)
+		}
+	}
+
+	chaincfg.Active = &chaincfg.RegTestParams
+
+	alloc := &chaincfg.Genesis{Alloc: []chaincfg.GenesisAlloc{{Address: walletAddress(wallet.MakeWallet()), Amount: 100}}}
+	first, err := GenesisBlock(alloc, ConsensusConfig{Engine: PoWEngine})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := GenesisBlock(alloc, ConsensusConfig{Engine: PoWEngine})
+
+	if bytes.Compare(first.Hash, second.Hash) != 0 {
+		t.Errorf("genesis of the same file is built as %x and %x", first.Hash, second.Hash)
+	}
+
+	if hex.EncodeToString(first.Hash) == chaincfg.RegTestParams.GenesisHash {
+		t.Error("premined genesis is the public regtest genesis")
+	}
+
+	// address with broken checksum
+	address := []byte(alloc.Alloc[0].Address)
+	last := len(address) - 1
+
+	if address[last] == '2' {
+		address[last] = '3'
+	} else {
+		address[last] = '2'
+	}
+
+	alloc.Alloc[0].Address = string(address)
+
+	if _, err := GenesisBlock(alloc, ConsensusConfig{Engine: PoWEngine}); err == nil {
+		t.Error("genesis allocating to invalid address is built")
+	}
+}
@@ -2,7 +2,7 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,18 +11,41 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
+	"github.com/Dimashey/blockchain/chaincfg"
 	"github.com/Dimashey/blockchain/internal/util"
+	"github.com/Dimashey/blockchain/wallet"
 	"github.com/dgraph-io/badger"
 )
 
-const dbPath = "./tmp/blocks_%s"
+// genesisKey keeps hash of the genesis block
+var genesisKey = []byte("genesis")
 
-const genesisData = "First Transaction from Genesis"
+// dbPath is path of node database in data directory of the active network
+func dbPath(nodeId string) string {
+	return filepath.Join(chaincfg.Active.DataDir, fmt.Sprintf("blocks_%s", nodeId))
+}
 
 type Chain struct {
 	LastHash []byte
 	Database *badger.DB
+	// Clock is the node time adjusted by time reported by peers
+	Clock *MedianTime
+	// Engine seals and verifies blocks, it is chosen when the chain is created
+	Engine ConsensusEngine
+
+	// mu serializes updates of the main chain between concurrent connections
+	mu sync.Mutex
+}
+
+// Tip returns hash of the last block of the main chain, it is safe to call
+// while other connections add blocks
+func (c *Chain) Tip() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.LastHash
 }
 
 func (c *Chain) GetBlock(blockHash []byte) (Block, error) {
@@ -46,6 +69,17 @@ func (c *Chain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
+// HasBlock reports whether block is stored, either on the main chain or on a side branch
+func (c *Chain) HasBlock(blockHash []byte) bool {
+	err := c.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(blockHash)
+
+		return err
+	})
+
+	return err == nil
+}
+
 func (c *Chain) GetBlocksHashes() [][]byte {
 	var blocks [][]byte
 
@@ -86,14 +120,27 @@ func (c *Chain) GetBestHeight() int {
 	return lastBlock.Height
 }
 
-func (c *Chain) MineBlock(txs []*Transaction) *Block {
+// MineBlock seals block with txs on top of the main chain using the chain
+// consensus engine and adds it to the chain.
+// Sealing is aborted with ctx error when ctx is cancelled
+func (c *Chain) MineBlock(ctx context.Context, txs []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
+	var bits uint32
+	var timestamp int64
+
+	inBlock := make(map[string]bool)
 
 	for _, tx := range txs {
-		if !c.VerifyTransaction(tx) {
-			log.Panic("Invalid Transaction")
+		// transactions spending outputs of earlier transactions of the block can't be
+		// verified against the chain alone, they are validated once the block is connected
+		if !spendsAny(tx, inBlock) {
+			if err := c.checkTransaction(tx); err != nil {
+				return nil, err
+			}
 		}
+
+		inBlock[hex.EncodeToString(tx.ID)] = true
 	}
 
 	err := c.Database.View(func(txn *badger.Txn) error {
@@ -110,60 +157,186 @@ func (c *Chain) MineBlock(txs []*Transaction) *Block {
 		lastBlock := Deserialize(lastBlockValue)
 		lastHeight = lastBlock.Height
 
+		bits, err = c.Engine.NextBits(txn, lastBlock)
+
+		if err != nil {
+			return err
+		}
+
+		// block has to be newer than median time past even if the clock is behind
+		pastMedian, err := medianTimePast(txn, lastBlock)
+		timestamp = c.Clock.AdjustedTime()
+
+		if timestamp <= pastMedian {
+			timestamp = pastMedian + 1
+		}
+
 		return err
 	})
 
 	util.HandleError(err)
 
-	newBlock := CreateBlock(txs, lastHash, lastHeight+1)
+	newBlock := NewBlock(txs, lastHash, lastHeight+1, bits, timestamp)
 
-	err = c.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
+	if err := c.Engine.Seal(ctx, newBlock); err != nil {
+		return nil, err
+	}
 
-		util.HandleError(err)
+	if err := c.AddBlock(newBlock); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// Generate mines blocks one after another, paying their subsidy to address,
+// and returns them. It is only allowed on networks which mine blocks on demand
+func (c *Chain) Generate(ctx context.Context, blocks int, address string) ([]*Block, error) {
+	if !chaincfg.Active.MineBlocksOnDemand {
+		return nil, fmt.Errorf("blocks can't be generated on %s", chaincfg.Active.Name)
+	}
+
+	var generated []*Block
+
+	for i := 0; i < blocks; i++ {
+		coinbase := CoinbaseTx(address, "", c.GetBestHeight()+1, 0)
+		block, err := c.MineBlock(ctx, []*Transaction{coinbase})
+
+		if err != nil {
+			return generated, err
+		}
+
+		generated = append(generated, block)
+	}
+
+	return generated, nil
+}
+
+// GenesisHash returns hash of the first block of the chain, peers with
+// a different one belong to another chain
+func (c *Chain) GenesisHash() []byte {
+	var hash []byte
+
+	err := c.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(genesisKey)
 
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+		if err != nil {
+			return err
+		}
 
-		c.LastHash = newBlock.Hash
+		hash, err = item.ValueCopy(nil)
 
 		return err
 	})
 
+	if err == nil {
+		return hash
+	}
+
+	// chains created before the genesis hash was stored are walked back to it
+	iter := c.Iterator()
+
+	for {
+		block := iter.Next()
+
+		if len(block.PrevHash) == 0 {
+			return block.Hash
+		}
+	}
+}
+
+// GenesisSupply returns number of tokens premined by the genesis block. Its burnt subsidy
+// is not counted, as outputs which can't be spent are not issued tokens
+func (c *Chain) GenesisSupply() int {
+	genesis, err := c.GetBlock(c.GenesisHash())
+
 	util.HandleError(err)
 
-	return newBlock
+	supply := 0
+
+	for _, tx := range genesis.Transactions {
+		for _, out := range tx.Outputs {
+			if !IsUnspendable(out.Script) {
+				supply += out.Value
+			}
+		}
+	}
+
+	return supply
 }
 
-func (c *Chain) AddBlock(block *Block) {
-	err := c.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
-			return nil
+// spendsAny reports whether transaction spends output of any of transactions with IDs in set
+func spendsAny(tx *Transaction, set map[string]bool) bool {
+	if tx.IsCoinbase() {
+		return false
+	}
+
+	for _, in := range tx.Inputs {
+		if set[hex.EncodeToString(in.ID)] {
+			return true
 		}
+	}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		util.HandleError(err)
+	return false
+}
 
-		item, err := txn.Get([]byte("lh"))
-		util.HandleError(err)
-		lastHash, _ := item.ValueCopy(nil)
+// AddBlock validates and stores block and switches the main chain to the branch
+// with the most cumulative work, disconnecting and reconnecting
+// blocks along the fork path so the UTXO set always matches the main chain.
+// Blocks which parent is not known yet are kept until the parent arrives.
+// It is ProcessBlock for callers which don't follow changes of the main chain.
+func (c *Chain) AddBlock(block *Block) error {
+	_, _, err := c.ProcessBlock(block)
 
-		item, err = txn.Get(lastHash)
-		util.HandleError(err)
-		lastBlockData, _ := item.ValueCopy(nil)
+	return err
+}
 
-		lastBlock := Deserialize(lastBlockData)
+// ProcessBlock validates block, stores it and switches the main chain to the valid
+// branch with the most work. It returns the blocks disconnected from the main chain,
+// old tip first, and the blocks connected to it, fork point first.
+// The switch can happen even when block itself turns out to be invalid
+func (c *Chain) ProcessBlock(block *Block) (disconnected, connected []*Block, err error) {
+	// the only block without parent is the genesis block the chain was created with,
+	// others would be stored without being linked to the chain
+	if len(block.PrevHash) == 0 {
+		if genesis := c.GenesisHash(); bytes.Compare(block.Hash, genesis) != 0 {
+			return nil, nil, rejectf(RejectWrongGenesis, "block %x has no parent and is not genesis %x", block.Hash, genesis)
+		}
+	}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			util.HandleError(err)
-			c.LastHash = block.Hash
+	if err := ValidateBlock(c.Engine, block); err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateFutureDrift(block, c.Clock.AdjustedTime()); err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var candidates []*Block
+
+	err = c.Database.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(block.Hash); err == nil {
+			if code, invalid := invalidCode(txn, block.Hash); invalid {
+				return rejectf(code, "block %x is known to be invalid", block.Hash)
+			}
+
+			return nil
 		}
 
-		return nil
+		var err error
+		candidates, err = c.acceptBlock(txn, block)
+
+		return err
 	})
 
-	util.HandleError(err)
+	if err != nil || len(candidates) == 0 {
+		return nil, nil, err
+	}
+
+	return c.activateBestChain(block, candidates)
 }
 
 func DBexists(path string) bool {
@@ -174,17 +347,32 @@ func DBexists(path string) bool {
 	return true
 }
 
-func InitBlockChain(address, nodeId string) *Chain {
+// InitBlockChain creates chain of the active network sealed by the engine described by config,
+// genesis block commits to config so the chain is continued with the same engine.
+// Genesis file is optional, see GenesisBlock
+func InitBlockChain(nodeId string, config ConsensusConfig, genesisFile *chaincfg.Genesis) *Chain {
 	var lastHash []byte
 
-	path := fmt.Sprintf(dbPath, nodeId)
+	engine, err := NewEngine(config)
+
+	util.HandleError(err)
+
+	genesis, err := GenesisBlock(genesisFile, config)
+
+	util.HandleError(err)
+
+	path := dbPath(nodeId)
 
 	if DBexists(path) {
 		fmt.Println("Blockchain already exists")
 		runtime.Goexit()
 	}
 
-	opts := badger.DefaultOptions(dbPath)
+	err = os.MkdirAll(chaincfg.Active.DataDir, 0755)
+
+	util.HandleError(err)
+
+	opts := badger.DefaultOptions(path)
 
 	db, err := openDB(path, opts)
 
@@ -193,13 +381,18 @@ func InitBlockChain(address, nodeId string) *Chain {
 	err = db.Update(func(txn *badger.Txn) error {
 		// Check if blockchain is exists
 		if _, err := txn.Get([]byte("lh")); err == badger.ErrKeyNotFound {
-			cbtx := CoinbaseTx(address, genesisData)
-			genesis := Genesis(cbtx)
+			err = txn.Set(genesisKey, genesis.Hash)
+
+			util.HandleError(err)
 
 			err = txn.Set(genesis.Hash, genesis.Serialize())
 
 			util.HandleError(err)
 
+			err = connectBlock(txn, genesis)
+
+			util.HandleError(err)
+
 			err = txn.Set([]byte("lh"), genesis.Hash)
 
 			lastHash = genesis.Hash
@@ -216,11 +409,13 @@ func InitBlockChain(address, nodeId string) *Chain {
 		return err
 	})
 
-	return &Chain{lastHash, db}
+	util.HandleError(err)
+
+	return &Chain{LastHash: lastHash, Database: db, Clock: NewMedianTime(), Engine: engine}
 }
 
 func ContinueBlockChain(nodeId string) *Chain {
-	path := fmt.Sprintf(dbPath, nodeId)
+	path := dbPath(nodeId)
 	if DBexists(path) == false {
 		fmt.Println("No existing blockchain found, create one!")
 		runtime.Goexit()
@@ -228,7 +423,7 @@ func ContinueBlockChain(nodeId string) *Chain {
 
 	var lastHash []byte
 
-	opts := badger.DefaultOptions(dbPath)
+	opts := badger.DefaultOptions(path)
 
 	db, err := openDB(path, opts)
 
@@ -246,7 +441,20 @@ func ContinueBlockChain(nodeId string) *Chain {
 
 	util.HandleError(err)
 
-	chain := Chain{lastHash, db}
+	chain := Chain{LastHash: lastHash, Database: db, Clock: NewMedianTime()}
+
+	// the engine is the one genesis block commits to, see GenesisBlock
+	genesis, err := chain.GetBlock(chain.GenesisHash())
+
+	util.HandleError(err)
+
+	config, err := GenesisConsensus(&genesis)
+
+	util.HandleError(err)
+
+	chain.Engine, err = NewEngine(config)
+
+	util.HandleError(err)
 
 	return &chain
 }
@@ -266,6 +474,10 @@ func (c *Chain) FindUnspentTransactions() map[string]TxOutputs {
 
 		Outputs:
 			for outIdx, out := range tx.Outputs {
+				if IsUnspendable(out.Script) {
+					continue
+				}
+
 				if spentTXOs[txID] != nil {
 					for _, stxoIdx := range spentTXOs[txID] {
 						if stxoIdx == outIdx {
@@ -275,7 +487,10 @@ func (c *Chain) FindUnspentTransactions() map[string]TxOutputs {
 				}
 
 				outs := UTXOs[txID]
+				outs.Height = block.Height
+				outs.Coinbase = tx.IsCoinbase()
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
 				UTXOs[txID] = outs
 			}
 
@@ -315,34 +530,135 @@ func (c *Chain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("Transaction does not exist")
 }
 
-func (c *Chain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) {
+// FindTransactionProof finds block of the main chain containing transaction
+// and merkle proof of its inclusion, which can be checked with VerifyProof
+// against the block merkle root without the rest of block transactions
+func (c *Chain) FindTransactionProof(ID []byte) (*Block, []MerkleProofStep, error) {
+	iter := c.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, ID) == 0 {
+				proof, err := block.MerkleTree().Proof(ID)
+
+				return block, proof, err
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, nil, errors.New("Transaction does not exist")
+}
+
+// FindDataProof finds transaction of the main chain with data output carrying data,
+// see DataScript, and returns it with its block and merkle proof like FindTransactionProof
+func (c *Chain) FindDataProof(data []byte) (*Block, *Transaction, []MerkleProofStep, error) {
+	iter := c.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				if carried, ok := ExtractData(out.Script); ok && bytes.Compare(carried, data) == 0 {
+					proof, err := block.MerkleTree().Proof(tx.ID)
+
+					return block, tx, proof, err
+				}
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, nil, nil, errors.New("Data is not found")
+}
+
+func (c *Chain) SignTransaction(tx *Transaction, w *wallet.Wallet) {
+	util.HandleError(c.SignTransactionWithHashType(tx, w, SigHashAll))
+}
+
+// SignTransactionWithHashType signs inputs of w key with signatures of hashType
+func (c *Chain) SignTransactionWithHashType(tx *Transaction, w *wallet.Wallet, hashType SigHashType) error {
 	prevTXs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
 		prevTX, err := c.FindTransaction(in.ID)
-		util.HandleError(err)
+
+		if err != nil {
+			return err
+		}
+
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	return tx.SignWithHashType(w, prevTXs, hashType)
+}
+
+// SignMultisigTransaction adds signature of w key to inputs spending outputs
+// of multisig redeemScript and returns whether all required signatures are collected
+func (c *Chain) SignMultisigTransaction(tx *Transaction, w *wallet.Wallet, redeemScript []byte) (bool, error) {
+	prevTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		prevTX, err := c.FindTransaction(in.ID)
+
+		if err != nil {
+			return false, err
+		}
+
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	tx.Sign(privateKey, prevTXs)
+	return tx.SignMultisig(w, prevTXs, redeemScript)
 }
 
+// VerifyTransaction checks signatures of transaction and that it does not spend
+// coinbase outputs which are not mature for the next block yet
 func (c *Chain) VerifyTransaction(tx *Transaction) bool {
+	return c.checkTransaction(tx) == nil
+}
+
+// checkTransaction is VerifyTransaction telling why transaction can't be mined in the next block
+func (c *Chain) checkTransaction(tx *Transaction) error {
 	prevTXs := make(map[string]Transaction)
 
 	if tx.IsCoinbase() {
-		return true
+		return nil
+	}
+
+	UTXOSet := UTXOSet{c}
+
+	if !UTXOSet.IsMature(tx, c.GetBestHeight()+1) {
+		return rejectf(RejectImmatureCoinbase, "transaction %x spends immature coinbase", tx.ID)
+	}
+
+	if err := c.CheckLockTimes(tx); err != nil {
+		return err
 	}
 
 	for _, in := range tx.Inputs {
 		prevTX, err := c.FindTransaction(in.ID)
 
-		util.HandleError(err)
+		if err != nil {
+			return rejectf(RejectMissingInputs, "transaction %x spends unknown transaction %x", tx.ID, in.ID)
+		}
 
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.Verify(prevTXs)
+	if err := tx.Verify(prevTXs); err != nil {
+		return rejectf(RejectScriptFailed, "transaction %x: %s", tx.ID, err)
+	}
+
+	return nil
 }
 
 func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
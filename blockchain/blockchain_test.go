@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestAddBlockRejectsOtherGenesis(t *testing.T) {
+	useRegTest(t)
+
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, "genesis", owner, 100)
+
+	other, err := GenesisBlock(&chaincfg.Genesis{Alloc: []chaincfg.GenesisAlloc{{Address: walletAddress(owner), Amount: 200}}}, ConsensusConfig{Engine: PoWEngine})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = chain.AddBlock(other)
+
+	if invalid, ok := err.(*ValidationError); !ok || invalid.Code != RejectWrongGenesis {
+		t.Fatalf("genesis of another chain is added with error %v", err)
+	}
+
+	if chain.HasBlock(other.Hash) {
+		t.Error("genesis of another chain is stored")
+	}
+
+	own, err := chain.GetBlock(chain.GenesisHash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.AddBlock(&own); err != nil {
+		t.Errorf("own genesis is rejected: %s", err)
+	}
+}
+
+func TestNewTransactionNotEnoughFunds(t *testing.T) {
+	useRegTest(t)
+
+	alice := wallet.MakeWallet()
+	chain := newTestChain(t, "funds", alice, 100)
+	UTXOSet := UTXOSet{Blockchain: chain}
+
+	if _, err := NewTransaction(alice, walletAddress(wallet.MakeWallet()), 100, 1, &UTXOSet); !errors.Is(err, errNotEnoughFunds) {
+		t.Errorf("spending more than balance fails with %v, want %v", err, errNotEnoughFunds)
+	}
+}
+
+func TestMineBlockRejectsInvalidTransaction(t *testing.T) {
+	useRegTest(t)
+
+	alice, miner := wallet.MakeWallet(), walletAddress(wallet.MakeWallet())
+	chain := newTestChain(t, "mine", alice, 100)
+	UTXOSet := UTXOSet{Blockchain: chain}
+
+	tx, err := NewTransaction(alice, walletAddress(wallet.MakeWallet()), 30, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the signature does not cover the changed output
+	tx.Outputs[0].Value = 20
+	tx.ID = tx.Hash()
+
+	coinbase := CoinbaseTx(miner, "", 1, 0)
+	_, err = chain.MineBlock(context.Background(), []*Transaction{coinbase, tx})
+
+	if invalid, ok := err.(*ValidationError); !ok || invalid.Code != RejectScriptFailed {
+		t.Errorf("block with forged transaction is mined with error %v, want %s", err, RejectScriptFailed)
+	}
+
+	if height := chain.GetBestHeight(); height != 0 {
+		t.Errorf("best height is %d, want 0", height)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	useRegTest(t)
+
+	miner := wallet.MakeWallet()
+	chain := newTestChain(t, "generate", wallet.MakeWallet(), 100)
+
+	blocks, err := chain.Generate(context.Background(), 5, walletAddress(miner))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != 5 || chain.GetBestHeight() != 5 || bytes.Compare(chain.Tip(), blocks[4].Hash) != 0 {
+		t.Fatalf("%d blocks are generated up to height %d", len(blocks), chain.GetBestHeight())
+	}
+
+	for i, block := range blocks {
+		if block.Height != i+1 {
+			t.Errorf("block %d is generated at height %d", i, block.Height)
+		}
+	}
+
+	// rewards of all but the last CoinbaseMaturity-1 blocks are spendable
+	spendable, immature := UTXOSet{Blockchain: chain}.FindBalance(LockScript(walletAddress(miner)))
+	mature := 5 - chaincfg.Active.CoinbaseMaturity + 1
+
+	if spendable != mature*Subsidy(1) || spendable+immature != 5*Subsidy(1) {
+		t.Errorf("miner has %d spendable and %d immature, want %d and %d", spendable, immature, mature*Subsidy(1), (5-mature)*Subsidy(1))
+	}
+
+	params := *chaincfg.Active
+	params.MineBlocksOnDemand = false
+	chaincfg.Active = &params
+
+	if _, err := chain.Generate(context.Background(), 1, walletAddress(miner)); err == nil {
+		t.Error("block is generated on network without mining on demand")
+	}
+}
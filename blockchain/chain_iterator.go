@@ -12,7 +12,7 @@ type BlockChainIterator struct {
 
 // Iterator returns iterator which go through blockchain in reverse order from last to genesis block
 func (c *Chain) Iterator() *BlockChainIterator {
-	iter := &BlockChainIterator{c.LastHash, c.Database}
+	iter := &BlockChainIterator{c.Tip(), c.Database}
 
 	return iter
 }
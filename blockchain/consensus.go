@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Dimashey/blockchain/internal/util"
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	PoWEngine = "pow"
+	PoAEngine = "poa"
+)
+
+// ConsensusEngine decides who may produce blocks and how block headers are sealed
+type ConsensusEngine interface {
+	// Seal completes header of assembled block, so it is accepted by VerifySeal
+	Seal(ctx context.Context, block *Block) error
+	// VerifySeal checks that block header is sealed according to the engine rules
+	VerifySeal(block *Block) error
+	// NextBits returns compact difficulty target of the block following parent
+	NextBits(txn *badger.Txn, parent *Block) (uint32, error)
+}
+
+// ConsensusConfig is committed to by the genesis block, so every node
+// following the chain uses the engine and signers it was created with
+type ConsensusConfig struct {
+	Engine string
+	// Signers are public key hashes allowed to seal blocks with proof-of-authority
+	Signers [][]byte
+}
+
+// Serialize returns canonical encoding of config, see encoding.go
+func (config ConsensusConfig) Serialize() []byte {
+	var e encoder
+
+	config.encode(&e)
+
+	return e.buf.Bytes()
+}
+
+func DeserializeConsensusConfig(data []byte) ConsensusConfig {
+	var config ConsensusConfig
+
+	util.HandleError(decodeRecord(data, config.decode))
+
+	return config
+}
+
+// genesisSeal returns Seal of the genesis block committing to config.
+// It is empty for proof-of-work, so genesis blocks of the networks keep their hashes
+func (config ConsensusConfig) genesisSeal() []byte {
+	if config.Engine == "" || config.Engine == PoWEngine {
+		return nil
+	}
+
+	return config.Serialize()
+}
+
+// GenesisConsensus returns consensus config genesis block commits to, see GenesisBlock
+func GenesisConsensus(genesis *Block) (ConsensusConfig, error) {
+	var config ConsensusConfig
+
+	if len(genesis.Seal) == 0 {
+		return ConsensusConfig{Engine: PoWEngine}, nil
+	}
+
+	if err := decodeRecord(genesis.Seal, config.decode); err != nil {
+		return ConsensusConfig{}, err
+	}
+
+	return config, nil
+}
+
+// NewEngine creates consensus engine described by config
+func NewEngine(config ConsensusConfig) (ConsensusEngine, error) {
+	switch config.Engine {
+	case "", PoWEngine:
+		return &ProofOfWorkEngine{}, nil
+	case PoAEngine:
+		if len(config.Signers) == 0 {
+			return nil, fmt.Errorf("proof-of-authority requires at least one signer")
+		}
+
+		return &ProofOfAuthority{Signers: config.Signers}, nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine %q", config.Engine)
+	}
+}
+
+// ProofOfWorkEngine seals blocks by searching for nonce giving hash below the target
+// and retargets difficulty every retarget interval blocks of the active network
+type ProofOfWorkEngine struct {
+	mu       sync.Mutex
+	hashRate float64
+}
+
+func (e *ProofOfWorkEngine) Seal(ctx context.Context, block *Block) error {
+	pow := NewProof(block)
+	start := time.Now()
+
+	err := pow.Mine(ctx)
+
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		e.mu.Lock()
+		e.hashRate = float64(pow.Hashes) / elapsed
+		e.mu.Unlock()
+	}
+
+	return err
+}
+
+// HashRate returns hashes per second tried while sealing the last block
+func (e *ProofOfWorkEngine) HashRate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.hashRate
+}
+
+func (e *ProofOfWorkEngine) VerifySeal(block *Block) error {
+	if block.Height == 0 && len(block.Seal) != 0 {
+		return rejectf(RejectBadSeal, "genesis block commits to another consensus engine")
+	}
+
+	pow := NewProof(block)
+
+	if pow.Target.Sign() <= 0 || pow.Target.Cmp(powLimit()) > 0 {
+		return rejectf(RejectBadDifficulty, "block bits %08x are out of range", block.Bits)
+	}
+
+	if !pow.Validate() {
+		return rejectf(RejectInvalidPoW, "block hash %x does not satisfy proof-of-work", block.Hash)
+	}
+
+	return nil
+}
+
+func (e *ProofOfWorkEngine) NextBits(txn *badger.Txn, parent *Block) (uint32, error) {
+	return nextBits(txn, parent)
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestCheckOutputs(t *testing.T) {
+	useRegTest(t)
+
+	data, _ := DataScript([]byte("hello"))
+	to := walletAddress(wallet.MakeWallet())
+
+	outputs := []struct {
+		name    string
+		outputs []TxOutput
+		code    RejectCode
+	}{
+		{"payment", []TxOutput{*NewTXOutput(10, to)}, ""},
+		{"data output", []TxOutput{*NewTXOutput(10, to), {0, data}}, ""},
+		{"negative output", []TxOutput{*NewTXOutput(-1, to)}, RejectBadValue},
+		{"data output with value", []TxOutput{{1, data}}, RejectBadDataCarrier},
+		{"data output with two pushes", []TxOutput{{0, NewScriptBuilder().AddOp(OP_RETURN).AddData([]byte{1}).AddData([]byte{2}).Script()}}, RejectBadDataCarrier},
+		{"data output too large", []TxOutput{{0, NewScriptBuilder().AddOp(OP_RETURN).AddData(make([]byte, MaxDataCarrierSize+1)).Script()}}, RejectBadDataCarrier},
+	}
+
+	for _, o := range outputs {
+		err := CheckOutputs(&Transaction{Outputs: o.outputs})
+
+		if o.code == "" && err != nil {
+			t.Errorf("%s is rejected: %s", o.name, err)
+		}
+
+		if o.code != "" && !rejected(err, o.code) {
+			t.Errorf("%s is checked with error %v, want %s", o.name, err, o.code)
+		}
+	}
+}
+
+// TestDataTransaction records data in the chain and proves it is included
+func TestDataTransaction(t *testing.T) {
+	useRegTest(t)
+
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, "data", owner, 100)
+	miner := walletAddress(wallet.MakeWallet())
+	data := []byte("document hash")
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewDataTransaction(owner, data, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, tx)
+
+	if got := balance(chain, walletAddress(owner)); got != 99 {
+		t.Errorf("balance of owner is %d, want 99", got)
+	}
+
+	block, found, proof, err := chain.FindDataProof(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(found.ID, tx.ID) != 0 || !VerifyProof(block.MerkleRoot, tx.ID, proof) {
+		t.Errorf("data is proven by transaction %x in block %x", found.ID, block.Hash)
+	}
+
+	// value sent to data output would be burnt
+	burn := &Transaction{Inputs: tx.Inputs, Outputs: append([]TxOutput{}, tx.Outputs...)}
+
+	for i := range burn.Outputs {
+		if IsUnspendable(burn.Outputs[i].Script) {
+			burn.Outputs[i].Value = 1
+		}
+	}
+
+	burn.ID = burn.Hash()
+	genesis := genesisBlock(t, chain)
+
+	if err := ValidateBlock(chain.Engine, buildBlock(t, chain, genesis, miner, 0, burn)); !rejected(err, RejectBadDataCarrier) {
+		t.Errorf("block with value sent to data output is validated with error %v, want %s", err, RejectBadDataCarrier)
+	}
+}
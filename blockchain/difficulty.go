@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"math/big"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/dgraph-io/badger"
+)
+
+// maxAdjustment limits how many times target can change during one retarget
+const maxAdjustment = 4
+
+// InitialBits returns the compact target of the genesis block of the active network
+func InitialBits() uint32 {
+	return BigToCompact(new(big.Int).Lsh(big.NewInt(1), uint(256-chaincfg.Active.Difficulty)))
+}
+
+// powLimit returns the easiest target a block of the active network may have
+func powLimit() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(256-chaincfg.Active.PowLimitBits))
+}
+
+// CompactToBig converts compact representation of target, where the highest byte
+// is the length of number in bytes and the rest 3 bytes are its most significant digits
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	exponent := uint(compact >> 24)
+
+	if compact&0x00800000 != 0 {
+		// negative targets are never valid
+		return big.NewInt(0)
+	}
+
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+
+	target := big.NewInt(mantissa)
+
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact converts target to its compact representation, see CompactToBig
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	exponent := uint(len(target.Bytes()))
+	var mantissa uint32
+
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+
+	// the highest mantissa bit is a sign, so move number by one byte
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// nextBits returns compact target required for the block following parent.
+// Every retarget interval blocks target is scaled by ratio of actual time spent
+// on the last interval to the expected one.
+func nextBits(txn *badger.Txn, parent *Block) (uint32, error) {
+	params := chaincfg.Active
+	height := parent.Height + 1
+
+	if params.NoRetargeting || height%params.RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+
+	for i := 0; i < params.RetargetInterval-1; i++ {
+		var err error
+
+		if first, err = getBlock(txn, first.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+
+	expected := int64((params.RetargetInterval - 1) * params.TargetBlockInterval)
+	actual := parent.Timestamp - first.Timestamp
+
+	if actual < expected/maxAdjustment {
+		actual = expected / maxAdjustment
+	}
+
+	if actual > expected*maxAdjustment {
+		actual = expected * maxAdjustment
+	}
+
+	target := CompactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	if limit := powLimit(); target.Cmp(limit) > 0 {
+		target.Set(limit)
+	}
+
+	return BigToCompact(target), nil
+}
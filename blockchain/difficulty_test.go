@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+func TestCompactTarget(t *testing.T) {
+	vectors := []struct {
+		compact uint32
+		target  string
+	}{
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x1b0404cb, "404cb000000000000000000000000000000000000000000000000"},
+		{0x03123456, "123456"},
+		{0x02008000, "80"},
+	}
+
+	for _, v := range vectors {
+		want, _ := new(big.Int).SetString(v.target, 16)
+
+		if got := CompactToBig(v.compact); got.Cmp(want) != 0 {
+			t.Errorf("target of %08x is %x, want %x", v.compact, got, want)
+		}
+
+		if got := BigToCompact(want); got != v.compact {
+			t.Errorf("compact of %x is %08x, want %08x", want, got, v.compact)
+		}
+	}
+
+	if got := CompactToBig(0x1d80ffff); got.Sign() != 0 {
+		t.Errorf("negative target is %x, want 0", got)
+	}
+}
+
+// TestNextBits retargets interval of blocks found faster, slower and as fast as expected
+func TestNextBits(t *testing.T) {
+	useRegTest(t)
+
+	chain := newTestChain(t, "retarget", wallet.MakeWallet(), 100)
+
+	params := chaincfg.Active
+	params.NoRetargeting = false
+	params.RetargetInterval = 4
+	params.TargetBlockInterval = 4
+
+	// 3 block intervals pass between the first and the last block of retarget interval
+	expected := int64(12)
+	bits := BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-16))
+
+	scaled := func(num, denom int64) uint32 {
+		target := CompactToBig(bits)
+		target.Mul(target, big.NewInt(num))
+
+		return BigToCompact(target.Div(target, big.NewInt(denom)))
+	}
+
+	cases := []struct {
+		name         string
+		interval     int64
+		powLimitBits int
+		want         uint32
+	}{
+		{"on time", expected, 8, bits},
+		{"twice as fast", expected / 2, 8, scaled(1, 2)},
+		{"twice as slow", expected * 2, 8, scaled(2, 1)},
+		{"too fast", 1, 8, scaled(1, maxAdjustment)},
+		{"too slow", expected * 100, 8, scaled(maxAdjustment, 1)},
+		{"slower than the limit", expected * 4, 15, BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-15))},
+	}
+
+	for _, c := range cases {
+		var got, between uint32
+
+		params.PowLimitBits = c.powLimitBits
+
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			var parent *Block
+
+			// heights 4 to 7 with the last one before the retarget at height 8
+			for height := 4; height < 8; height++ {
+				timestamp := int64(1000)
+
+				if height == 7 {
+					timestamp += c.interval
+				}
+
+				var prevHash []byte
+
+				if parent != nil {
+					prevHash = parent.Hash
+				}
+
+				block := NewBlock([]*Transaction{CoinbaseTx(walletAddress(wallet.MakeWallet()), "", height, 0)}, prevHash, height, bits, timestamp)
+				block.Hash = block.BlockHeader.Hash()
+
+				if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+					return err
+				}
+
+				parent = block
+			}
+
+			var err error
+
+			if got, err = nextBits(txn, parent); err != nil {
+				return err
+			}
+
+			grandparent, err := getBlock(txn, parent.PrevHash)
+
+			if err != nil {
+				return err
+			}
+
+			between, err = nextBits(txn, grandparent)
+
+			return err
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != c.want {
+			t.Errorf("%s: bits are %08x, want %08x", c.name, got, c.want)
+		}
+
+		if between != bits {
+			t.Errorf("%s: bits change to %08x between retargets", c.name, between)
+		}
+	}
+}
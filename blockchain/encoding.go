@@ -0,0 +1,532 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Canonical binary encoding of blocks and transactions.
+// It is used for hashing, storage and the wire, so IDs do not depend on Go types.
+//
+// Primitive types:
+//
+//	uint32  4 bytes, big-endian
+//	int32   4 bytes, big-endian two's complement
+//	int64   8 bytes, big-endian two's complement
+//	varint  unsigned LEB128, 7 bits per byte starting from the lowest ones,
+//	        the highest bit of byte is set when more bytes follow
+//	bytes   varint length followed by raw bytes
+//	bool    1 byte, 0 or 1
+//
+// TxOutput:
+//
+//	int64   Value
+//	bytes   Script, locking script
+//
+// TxInput:
+//
+//	bytes   ID of transaction with spent output, empty for coinbase
+//	int32   Out, index of spent output, -1 for coinbase
+//	bytes   Script, unlocking script or arbitrary data for coinbase
+//	uint32  Sequence
+//
+// Transaction, its ID is not encoded itself. Unlocking scripts are the witness of transaction:
+// ID is sha256 of this encoding with unlocking scripts left empty, so signatures can't change it,
+// witness hash is sha256 of the full encoding. Coinbase data is not a witness and is always encoded:
+//
+//	uint32  encoding version, currently 4
+//	varint  number of inputs, followed by inputs
+//	varint  number of outputs, followed by outputs
+//	uint32  LockTime
+//
+// BlockHeader, block hash is sha256 of this encoding,
+// seal hash is sha256 of this encoding with empty Seal:
+//
+//	uint32  Version, currently 1
+//	bytes   PrevHash
+//	bytes   MerkleRoot, merkle root of transaction IDs
+//	bytes   WitnessRoot, merkle root of transaction witness hashes
+//	int64   Timestamp
+//	uint32  Bits
+//	int64   Nonce
+//	int64   Height
+//	bytes   Seal, engine specific, empty for proof-of-work
+//
+// Block, its hash is derived from the header and is not encoded itself:
+//
+//	        BlockHeader
+//	varint  number of transactions, followed by transactions as bytes
+//
+// Records kept in the database only start with their own encoding version, see recordEncodingVersion.
+//
+// TxOutputs, unspent outputs of a transaction in the UTXO set:
+//
+//	uint32  encoding version, currently 1
+//	varint  number of outputs, followed by int32 index of output in transaction and TxOutput
+//	int64   Height
+//	bool    Coinbase
+//
+// BlockUndo, outputs spent by a block:
+//
+//	uint32  encoding version, currently 1
+//	varint  number of spent outputs, followed by them:
+//	          bytes ID, int32 Index, TxOutput Output, int64 Height, bool Coinbase
+//
+// ConsensusConfig:
+//
+//	uint32  encoding version, currently 1
+//	bytes   Engine
+//	varint  number of signers, followed by signer public key hashes as bytes
+//
+// Test vectors, checked by encoding_test.go:
+//
+//	TxOutput{Value: 20, Script: 0xaabb}
+//	  00000000 00000014 02 aabb
+//
+//	TxInput{ID: 0x0102, Out: 1, Script: 0x03, Sequence: 0xffffffff}
+//	  02 0102 00000001 01 03 ffffffff
+//
+//	Transaction{Inputs: [TxInput{ID: empty, Out: -1, Script: "genesis", Sequence: 0xffffffff}],
+//	            Outputs: [TxOutput{Value: 20, Script: 0xaabb}], LockTime: 0}
+//	  00000004
+//	  01 00 ffffffff 07 67656e65736973 ffffffff
+//	  01 00000000 00000014 02 aabb
+//	  00000000
+//	  ID: c412fb5f9e7b9ec21623dda1e8a318158f8c214be9f9920d0357d81ed27a0db9
+//
+//	Transaction{Inputs: [TxInput{ID: 0x0102, Out: 1, Script: 0x03, Sequence: 0xffffffff}],
+//	            Outputs: [TxOutput{Value: 20, Script: 0xaabb}], LockTime: 0}
+//	  00000004
+//	  01 02 0102 00000001 01 03 ffffffff
+//	  01 00000000 00000014 02 aabb
+//	  00000000
+//	  ID:           88851d0510c0a3d6ecc5c14288e67c7dc1642d704488e10dbe6c543e516e687a
+//	  witness hash: 53dc0bf937e60f47630f4455e39c44c0f92ebc27e73079e8889d4728eee94c55
+//
+//	TxOutputs{Outputs: [TxOutput{Value: 20, Script: 0xaabb}], Indexes: [2], Height: 5, Coinbase: true}
+//	  00000001 01 00000002 00000000 00000014 02 aabb 0000000000000005 01
+//
+//	BlockUndo{Spent: [SpentOutput{ID: 0x0102, Index: 1, Output: TxOutput{Value: 20, Script: 0xaabb},
+//	                              Height: 5, Coinbase: false}]}
+//	  00000001 01 02 0102 00000001 00000000 00000014 02 aabb 0000000000000005 00
+//
+//	ConsensusConfig{Engine: "poa", Signers: [0xaabb]}
+//	  00000001 03 706f61 01 02 aabb
+
+// txEncodingVersion is the first field of encoded transaction. It is bumped with every change
+// of the encoding or of what ID covers, so transactions of another format are rejected
+// instead of being misread:
+//
+//	1  inputs carry signature and public key, outputs carry public key hash
+//	2  inputs and outputs carry scripts
+//	3  inputs carry Sequence, transaction carries LockTime
+//	4  ID leaves out unlocking scripts
+const txEncodingVersion = 4
+
+// recordEncodingVersion is the first field of TxOutputs, BlockUndo and ConsensusConfig records,
+// bumped with every change of their encoding
+const recordEncodingVersion = 1
+
+var errMalformed = errors.New("malformed data")
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) writeInt32(v int32) {
+	e.writeUint32(uint32(v))
+}
+
+func (e *encoder) writeInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) writeVarInt(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf.Write(b[:n])
+}
+
+func (e *encoder) writeBytes(data []byte) {
+	e.writeVarInt(uint64(len(data)))
+	e.buf.Write(data)
+}
+
+func (e *encoder) writeBool(v bool) {
+	if v {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+}
+
+// decoder remembers the first error, so fields can be read one by one
+// and the error checked once at the end
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{r: bytes.NewReader(data)}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || n > d.r.Len() {
+		d.err = errMalformed
+		return nil
+	}
+
+	b := make([]byte, n)
+
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = err
+		return nil
+	}
+
+	return b
+}
+
+func (d *decoder) readUint32() uint32 {
+	b := d.read(4)
+
+	if d.err != nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) readInt32() int32 {
+	return int32(d.readUint32())
+}
+
+func (d *decoder) readInt64() int64 {
+	b := d.read(8)
+
+	if d.err != nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) readVarInt() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(d.r)
+
+	if err != nil {
+		d.err = errMalformed
+	}
+
+	return v
+}
+
+// readCount reads number of items, each of them takes at least one byte
+func (d *decoder) readCount() int {
+	n := d.readVarInt()
+
+	if n > uint64(d.r.Len()) {
+		d.err = errMalformed
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *decoder) readBytes() []byte {
+	return d.read(d.readCount())
+}
+
+func (d *decoder) readBool() bool {
+	b := d.read(1)
+
+	if d.err != nil {
+		return false
+	}
+
+	if b[0] > 1 {
+		d.err = errMalformed
+	}
+
+	return b[0] == 1
+}
+
+// readRecordVersion checks version of a database record
+func (d *decoder) readRecordVersion(record string) {
+	if version := d.readUint32(); d.err == nil && version != recordEncodingVersion {
+		d.err = fmt.Errorf("unknown %s encoding version %d", record, version)
+	}
+}
+
+// finish returns decoding error, trailing bytes are treated as an error as well
+func (d *decoder) finish() error {
+	if d.err == nil && d.r.Len() != 0 {
+		d.err = errMalformed
+	}
+
+	return d.err
+}
+
+func (out *TxOutput) encode(e *encoder) {
+	e.writeInt64(int64(out.Value))
+	e.writeBytes(out.Script)
+}
+
+func (out *TxOutput) decode(d *decoder) {
+	out.Value = int(d.readInt64())
+	out.Script = d.readBytes()
+}
+
+func (in *TxInput) encode(e *encoder, witness bool) {
+	e.writeBytes(in.ID)
+	e.writeInt32(int32(in.Out))
+
+	if witness {
+		e.writeBytes(in.Script)
+	} else {
+		e.writeBytes(nil)
+	}
+
+	e.writeUint32(in.Sequence)
+}
+
+func (in *TxInput) decode(d *decoder) {
+	in.ID = d.readBytes()
+	in.Out = int(d.readInt32())
+	in.Script = d.readBytes()
+	in.Sequence = d.readUint32()
+}
+
+// encode writes transaction, without witness unlocking scripts are left empty,
+// but coinbase data is kept as it is not a witness
+func (tx *Transaction) encode(e *encoder, witness bool) {
+	witness = witness || tx.IsCoinbase()
+
+	e.writeUint32(txEncodingVersion)
+	e.writeVarInt(uint64(len(tx.Inputs)))
+
+	for i := range tx.Inputs {
+		tx.Inputs[i].encode(e, witness)
+	}
+
+	e.writeVarInt(uint64(len(tx.Outputs)))
+
+	for i := range tx.Outputs {
+		tx.Outputs[i].encode(e)
+	}
+
+	e.writeUint32(tx.LockTime)
+}
+
+func (tx *Transaction) decode(d *decoder) {
+	if version := d.readUint32(); d.err == nil && version != txEncodingVersion {
+		d.err = fmt.Errorf("unknown transaction encoding version %d", version)
+		return
+	}
+
+	tx.Inputs = make([]TxInput, d.readCount())
+
+	for i := range tx.Inputs {
+		tx.Inputs[i].decode(d)
+	}
+
+	tx.Outputs = make([]TxOutput, d.readCount())
+
+	for i := range tx.Outputs {
+		tx.Outputs[i].decode(d)
+	}
+
+	tx.LockTime = d.readUint32()
+}
+
+func (h *BlockHeader) encode(e *encoder) {
+	e.writeUint32(h.Version)
+	e.writeBytes(h.PrevHash)
+	e.writeBytes(h.MerkleRoot)
+	e.writeBytes(h.WitnessRoot)
+	e.writeInt64(h.Timestamp)
+	e.writeUint32(h.Bits)
+	e.writeInt64(h.Nonce)
+	e.writeInt64(int64(h.Height))
+	e.writeBytes(h.Seal)
+}
+
+func (h *BlockHeader) decode(d *decoder) {
+	if h.Version = d.readUint32(); d.err == nil && h.Version != BlockVersion {
+		d.err = fmt.Errorf("unknown block version %d", h.Version)
+		return
+	}
+
+	h.PrevHash = d.readBytes()
+	h.MerkleRoot = d.readBytes()
+	h.WitnessRoot = d.readBytes()
+	h.Timestamp = d.readInt64()
+	h.Bits = d.readUint32()
+	h.Nonce = d.readInt64()
+	h.Height = int(d.readInt64())
+	h.Seal = d.readBytes()
+}
+
+func (b *Block) encode(e *encoder) {
+	b.BlockHeader.encode(e)
+	e.writeVarInt(uint64(len(b.Transactions)))
+
+	for _, tx := range b.Transactions {
+		e.writeBytes(tx.Serialize())
+	}
+}
+
+func (b *Block) decode(d *decoder) {
+	b.BlockHeader.decode(d)
+	b.Transactions = make([]*Transaction, d.readCount())
+
+	for i := range b.Transactions {
+		tx, err := DecodeTransaction(d.readBytes())
+
+		if d.err == nil && err != nil {
+			d.err = err
+		}
+
+		b.Transactions[i] = &tx
+	}
+}
+
+func (outs *TxOutputs) encode(e *encoder) {
+	e.writeUint32(recordEncodingVersion)
+	e.writeVarInt(uint64(len(outs.Outputs)))
+
+	for i := range outs.Outputs {
+		e.writeInt32(int32(outs.Indexes[i]))
+		outs.Outputs[i].encode(e)
+	}
+
+	e.writeInt64(int64(outs.Height))
+	e.writeBool(outs.Coinbase)
+}
+
+func (outs *TxOutputs) decode(d *decoder) {
+	d.readRecordVersion("outputs")
+
+	n := d.readCount()
+	outs.Outputs = make([]TxOutput, n)
+	outs.Indexes = make([]int, n)
+
+	for i := range outs.Outputs {
+		outs.Indexes[i] = int(d.readInt32())
+		outs.Outputs[i].decode(d)
+	}
+
+	outs.Height = int(d.readInt64())
+	outs.Coinbase = d.readBool()
+}
+
+func (undo *BlockUndo) encode(e *encoder) {
+	e.writeUint32(recordEncodingVersion)
+	e.writeVarInt(uint64(len(undo.Spent)))
+
+	for i := range undo.Spent {
+		spent := &undo.Spent[i]
+
+		e.writeBytes(spent.ID)
+		e.writeInt32(int32(spent.Index))
+		spent.Output.encode(e)
+		e.writeInt64(int64(spent.Height))
+		e.writeBool(spent.Coinbase)
+	}
+}
+
+func (undo *BlockUndo) decode(d *decoder) {
+	d.readRecordVersion("undo")
+
+	undo.Spent = make([]SpentOutput, d.readCount())
+
+	for i := range undo.Spent {
+		spent := &undo.Spent[i]
+
+		spent.ID = d.readBytes()
+		spent.Index = int(d.readInt32())
+		spent.Output.decode(d)
+		spent.Height = int(d.readInt64())
+		spent.Coinbase = d.readBool()
+	}
+}
+
+func (config *ConsensusConfig) encode(e *encoder) {
+	e.writeUint32(recordEncodingVersion)
+	e.writeBytes([]byte(config.Engine))
+	e.writeVarInt(uint64(len(config.Signers)))
+
+	for _, signer := range config.Signers {
+		e.writeBytes(signer)
+	}
+}
+
+func (config *ConsensusConfig) decode(d *decoder) {
+	d.readRecordVersion("consensus config")
+
+	config.Engine = string(d.readBytes())
+	config.Signers = make([][]byte, d.readCount())
+
+	for i := range config.Signers {
+		config.Signers[i] = d.readBytes()
+	}
+}
+
+// decodeRecord parses database record with decode, trailing bytes are an error
+func decodeRecord(data []byte, decode func(d *decoder)) error {
+	d := newDecoder(data)
+	decode(d)
+
+	return d.finish()
+}
+
+// DecodeTransaction parses canonical encoding of transaction and derives its ID
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var tx Transaction
+
+	d := newDecoder(data)
+	tx.decode(d)
+
+	if err := d.finish(); err != nil {
+		return Transaction{}, err
+	}
+
+	tx.ID = tx.Hash()
+
+	return tx, nil
+}
+
+// DecodeBlock parses canonical encoding of block and derives its hash
+func DecodeBlock(data []byte) (*Block, error) {
+	var block Block
+
+	d := newDecoder(data)
+	block.decode(d)
+
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	block.Hash = block.BlockHeader.Hash()
+
+	return &block, nil
+}
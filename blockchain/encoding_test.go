@@ -0,0 +1,200 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vector decodes hex test vector written as in the encoding.go comment, spaces are ignored
+func vector(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+
+	if err != nil {
+		t.Fatalf("bad vector %q: %s", s, err)
+	}
+
+	return data
+}
+
+var (
+	vectorOutput = TxOutput{Value: 20, Script: []byte{0xaa, 0xbb}}
+	vectorInput  = TxInput{ID: []byte{0x01, 0x02}, Out: 1, Script: []byte{0x03}, Sequence: 0xffffffff}
+)
+
+func vectorCoinbase() Transaction {
+	return Transaction{
+		Inputs:  []TxInput{{ID: []byte{}, Out: -1, Script: []byte("genesis"), Sequence: 0xffffffff}},
+		Outputs: []TxOutput{vectorOutput},
+	}
+}
+
+func vectorTransaction() Transaction {
+	return Transaction{Inputs: []TxInput{vectorInput}, Outputs: []TxOutput{vectorOutput}}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	var e encoder
+	vectorOutput.encode(&e)
+
+	if want := vector(t, "00000000 00000014 02 aabb"); !bytes.Equal(e.buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", e.buf.Bytes(), want)
+	}
+}
+
+func TestEncodeInput(t *testing.T) {
+	var e encoder
+	vectorInput.encode(&e, true)
+
+	if want := vector(t, "02 0102 00000001 01 03 ffffffff"); !bytes.Equal(e.buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", e.buf.Bytes(), want)
+	}
+}
+
+func TestEncodeTransaction(t *testing.T) {
+	tests := []struct {
+		name        string
+		tx          Transaction
+		encoding    string
+		id          string
+		witnessHash string
+	}{
+		{
+			name: "coinbase",
+			tx:   vectorCoinbase(),
+			encoding: "00000004" +
+				"01 00 ffffffff 07 67656e65736973 ffffffff" +
+				"01 00000000 00000014 02 aabb" +
+				"00000000",
+			id: "c412fb5f9e7b9ec21623dda1e8a318158f8c214be9f9920d0357d81ed27a0db9",
+			// coinbase data is not a witness, so both hashes cover the same bytes
+			witnessHash: "c412fb5f9e7b9ec21623dda1e8a318158f8c214be9f9920d0357d81ed27a0db9",
+		},
+		{
+			name: "spend",
+			tx:   vectorTransaction(),
+			encoding: "00000004" +
+				"01 02 0102 00000001 01 03 ffffffff" +
+				"01 00000000 00000014 02 aabb" +
+				"00000000",
+			id:          "88851d0510c0a3d6ecc5c14288e67c7dc1642d704488e10dbe6c543e516e687a",
+			witnessHash: "53dc0bf937e60f47630f4455e39c44c0f92ebc27e73079e8889d4728eee94c55",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, want := test.tx.Serialize(), vector(t, test.encoding); !bytes.Equal(got, want) {
+				t.Errorf("encoding is %x, want %x", got, want)
+			}
+
+			if got := hex.EncodeToString(test.tx.Hash()); got != test.id {
+				t.Errorf("ID is %s, want %s", got, test.id)
+			}
+
+			if got := hex.EncodeToString(test.tx.WitnessHash()); got != test.witnessHash {
+				t.Errorf("witness hash is %s, want %s", got, test.witnessHash)
+			}
+
+			decoded, err := DecodeTransaction(vector(t, test.encoding))
+
+			if err != nil {
+				t.Fatalf("decoding failed: %s", err)
+			}
+
+			if !bytes.Equal(decoded.ID, test.tx.Hash()) || !bytes.Equal(decoded.Serialize(), test.tx.Serialize()) {
+				t.Errorf("decoded transaction %x does not match", decoded.Serialize())
+			}
+		})
+	}
+}
+
+func TestDecodeTransactionRejectsOtherVersion(t *testing.T) {
+	data := vector(t, "00000003"+
+		"01 02 0102 00000001 01 03 ffffffff"+
+		"01 00000000 00000014 02 aabb"+
+		"00000000")
+
+	if _, err := DecodeTransaction(data); err == nil {
+		t.Error("transaction of encoding version 3 is decoded")
+	}
+}
+
+func TestDecodeTransactionRejectsTrailingBytes(t *testing.T) {
+	tx := vectorTransaction()
+	data := append(tx.Serialize(), 0x00)
+
+	if _, err := DecodeTransaction(data); err == nil {
+		t.Error("transaction with trailing byte is decoded")
+	}
+}
+
+func TestEncodeRecords(t *testing.T) {
+	outputs := TxOutputs{Outputs: []TxOutput{vectorOutput}, Indexes: []int{2}, Height: 5, Coinbase: true}
+	undo := BlockUndo{Spent: []SpentOutput{{ID: []byte{0x01, 0x02}, Index: 1, Output: vectorOutput, Height: 5}}}
+	config := ConsensusConfig{Engine: PoAEngine, Signers: [][]byte{{0xaa, 0xbb}}}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		decode   func(data []byte) interface{}
+		record   interface{}
+	}{
+		{
+			name:     "outputs",
+			data:     outputs.Serialize(),
+			encoding: "00000001 01 00000002 00000000 00000014 02 aabb 0000000000000005 01",
+			decode:   func(data []byte) interface{} { return DeserializeOutputs(data) },
+			record:   outputs,
+		},
+		{
+			name:     "undo",
+			data:     undo.Serialize(),
+			encoding: "00000001 01 02 0102 00000001 00000000 00000014 02 aabb 0000000000000005 00",
+			decode:   func(data []byte) interface{} { return DeserializeUndo(data) },
+			record:   undo,
+		},
+		{
+			name:     "consensus config",
+			data:     config.Serialize(),
+			encoding: "00000001 03 706f61 01 02 aabb",
+			decode:   func(data []byte) interface{} { return DeserializeConsensusConfig(data) },
+			record:   config,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := vector(t, test.encoding)
+
+			if !bytes.Equal(test.data, want) {
+				t.Errorf("encoding is %x, want %x", test.data, want)
+			}
+
+			if got := test.decode(want); !reflect.DeepEqual(got, test.record) {
+				t.Errorf("decoded record is %+v, want %+v", got, test.record)
+			}
+		})
+	}
+}
+
+func TestDecodeRecordRejectsOtherVersion(t *testing.T) {
+	var outputs TxOutputs
+
+	data := vector(t, "00000000 01 00000002 00000000 00000014 02 aabb 0000000000000005 01")
+
+	if err := decodeRecord(data, outputs.decode); err == nil {
+		t.Error("outputs of encoding version 0 are decoded")
+	}
+
+	data = vector(t, "00000001 01 00000002 00000000 00000014 02 aabb 0000000000000005 02")
+
+	if err := decodeRecord(data, outputs.decode); err == nil {
+		t.Error("outputs with malformed coinbase flag are decoded")
+	}
+}
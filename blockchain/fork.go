@@ -0,0 +1,392 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	workPrefix    = []byte("work-")
+	orphanPrefix  = []byte("orphan-")
+	invalidPrefix = []byte("invalid-")
+)
+
+// blockWork returns expected number of hashes required to find block
+// which is 2^256 / (target + 1)
+func blockWork(b *Block) *big.Int {
+	target := NewProof(b).Target
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	return numerator.Div(numerator, denominator)
+}
+
+func getBlock(txn *badger.Txn, hash []byte) (*Block, error) {
+	item, err := txn.Get(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	blockData, err := item.ValueCopy(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Deserialize(blockData), nil
+}
+
+// chainWork returns the total work of the branch ending with block hash.
+// Blocks stored before work tracking existed get their work computed and saved on demand
+func chainWork(txn *badger.Txn, hash []byte) (*big.Int, error) {
+	key := prefixedKey(workPrefix, hash)
+
+	if item, err := txn.Get(key); err == nil {
+		work, err := item.ValueCopy(nil)
+
+		return new(big.Int).SetBytes(work), err
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	block, err := getBlock(txn, hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	work := blockWork(block)
+
+	if len(block.PrevHash) != 0 {
+		parentWork, err := chainWork(txn, block.PrevHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		work.Add(work, parentWork)
+	}
+
+	return work, txn.Set(key, work.Bytes())
+}
+
+// acceptBlock stores block together with all orphans waiting for it
+// and returns the stored blocks which are linked to the chain.
+// Blocks which parent is still unknown are kept as orphans.
+func (c *Chain) acceptBlock(txn *badger.Txn, block *Block) ([]*Block, error) {
+	if len(block.PrevHash) != 0 {
+		if code, invalid := invalidCode(txn, block.PrevHash); invalid {
+			return nil, rejectf(RejectInvalidAncestor, "block %x builds on invalid block %x (%s)", block.Hash, block.PrevHash, code)
+		}
+	}
+
+	if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+		return nil, err
+	}
+
+	if len(block.PrevHash) != 0 {
+		if _, err := chainWork(txn, block.PrevHash); err == badger.ErrKeyNotFound {
+			orphanKey := prefixedKey(prefixedKey(orphanPrefix, block.PrevHash), block.Hash)
+
+			return nil, txn.Set(orphanKey, []byte{})
+		} else if err != nil {
+			return nil, err
+		}
+
+		parent, err := getBlock(txn, block.PrevHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateLink(block, parent); err != nil {
+			return nil, err
+		}
+
+		bits, err := c.Engine.NextBits(txn, parent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if block.Bits != bits {
+			return nil, rejectf(RejectBadDifficulty, "block bits are %08x, expected %08x", block.Bits, bits)
+		}
+
+		if err := validateTimestamp(txn, block, parent); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := chainWork(txn, block.Hash); err != nil {
+		return nil, err
+	}
+
+	accepted := []*Block{block}
+
+	for _, childHash := range orphansOf(txn, block.Hash) {
+		if err := txn.Delete(prefixedKey(prefixedKey(orphanPrefix, block.Hash), childHash)); err != nil {
+			return nil, err
+		}
+
+		child, err := getBlock(txn, childHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		descendants, err := c.acceptBlock(txn, child)
+
+		if _, invalid := err.(*ValidationError); invalid {
+			fmt.Printf("Orphan block %x is dropped: %s\n", child.Hash, err)
+
+			if err := txn.Delete(child.Hash); err != nil {
+				return nil, err
+			}
+
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		accepted = append(accepted, descendants...)
+	}
+
+	return accepted, nil
+}
+
+// invalidCode tells whether block hash failed to connect and why
+func invalidCode(txn *badger.Txn, hash []byte) (RejectCode, bool) {
+	item, err := txn.Get(prefixedKey(invalidPrefix, hash))
+
+	if err != nil {
+		return "", false
+	}
+
+	code, err := item.ValueCopy(nil)
+
+	return RejectCode(code), err == nil
+}
+
+// activateBestChain makes the valid candidate with the most work the head of the main chain.
+// Every switch runs in its own transaction, so a block failing to connect rolls back
+// only that switch: the block is marked invalid together with the blocks built on it,
+// and its parent competes with the remaining candidates instead.
+// It returns the blocks of the switch made, see reorganize, and an error when block
+// itself turned out to be invalid
+func (c *Chain) activateBestChain(block *Block, candidates []*Block) (disconnected, connected []*Block, err error) {
+	var blockErr error
+
+	for {
+		var tipHash []byte
+		var best *Block
+
+		err := c.Database.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte("lh"))
+
+			if err != nil {
+				return err
+			}
+
+			if tipHash, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+
+			bestWork, err := chainWork(txn, tipHash)
+
+			if err != nil {
+				return err
+			}
+
+			for _, candidate := range candidates {
+				if _, invalid := invalidCode(txn, candidate.Hash); invalid {
+					continue
+				}
+
+				work, err := chainWork(txn, candidate.Hash)
+
+				if err != nil {
+					return err
+				}
+
+				if work.Cmp(bestWork) > 0 {
+					best, bestWork = candidate, work
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil || best == nil {
+			return nil, nil, firstError(err, blockErr)
+		}
+
+		var detach, attach []*Block
+
+		err = c.Database.Update(func(txn *badger.Txn) error {
+			var err error
+
+			detach, attach, err = reorganize(txn, tipHash, best)
+
+			return err
+		})
+
+		if err == nil {
+			c.LastHash = best.Hash
+
+			return detach, attach, blockErr
+		}
+
+		invalid, ok := err.(*ValidationError)
+
+		if !ok {
+			return nil, nil, err
+		}
+
+		failed := attach[0]
+		fmt.Printf("Block %x is invalid: %s\n", failed.Hash, invalid)
+
+		var parent *Block
+
+		err = c.Database.Update(func(txn *badger.Txn) error {
+			for i, b := range attach {
+				code := invalid.Code
+
+				if i > 0 {
+					code = RejectInvalidAncestor
+				}
+
+				if err := txn.Set(prefixedKey(invalidPrefix, b.Hash), []byte(code)); err != nil {
+					return err
+				}
+			}
+
+			var err error
+			parent, err = getBlock(txn, failed.PrevHash)
+
+			return err
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, b := range attach {
+			if bytes.Compare(b.Hash, block.Hash) != 0 {
+				continue
+			}
+
+			if b == failed {
+				blockErr = invalid
+			} else {
+				blockErr = rejectf(RejectInvalidAncestor, "block %x builds on invalid block %x", block.Hash, failed.Hash)
+			}
+		}
+
+		candidates = append(candidates, parent)
+	}
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func orphansOf(txn *badger.Txn, parentHash []byte) [][]byte {
+	var children [][]byte
+
+	prefix := prefixedKey(orphanPrefix, parentHash)
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		children = append(children, bytes.TrimPrefix(it.Item().KeyCopy(nil), prefix))
+	}
+
+	return children
+}
+
+// reorganize makes newTip the head of the main chain. Blocks of the old branch
+// are disconnected down to the fork point and blocks of the new branch are
+// connected on top of it, keeping the UTXO set in sync. It returns the disconnected
+// blocks, old tip first, and the connected ones, fork point first. When a block
+// fails to connect, attach starts with it and holds the blocks built on it.
+func reorganize(txn *badger.Txn, oldTipHash []byte, newTip *Block) (detach, attach []*Block, err error) {
+	oldTip, err := getBlock(txn, oldTipHash)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldBranch, newBranch := oldTip, newTip
+
+	for oldBranch.Height > newBranch.Height {
+		detach = append(detach, oldBranch)
+
+		if oldBranch, err = getBlock(txn, oldBranch.PrevHash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for newBranch.Height > oldBranch.Height {
+		attach = append([]*Block{newBranch}, attach...)
+
+		if newBranch, err = getBlock(txn, newBranch.PrevHash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for bytes.Compare(oldBranch.Hash, newBranch.Hash) != 0 {
+		if len(oldBranch.PrevHash) == 0 || len(newBranch.PrevHash) == 0 {
+			return nil, nil, fmt.Errorf("block %x does not share genesis with the main chain", newTip.Hash)
+		}
+
+		detach = append(detach, oldBranch)
+		attach = append([]*Block{newBranch}, attach...)
+
+		if oldBranch, err = getBlock(txn, oldBranch.PrevHash); err != nil {
+			return nil, nil, err
+		}
+
+		if newBranch, err = getBlock(txn, newBranch.PrevHash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(detach) > 0 {
+		fmt.Printf("Reorganize: disconnecting %d blocks, connecting %d blocks from fork %x\n",
+			len(detach), len(attach), oldBranch.Hash)
+	}
+
+	for _, block := range detach {
+		if err := disconnectBlock(txn, block); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i, block := range attach {
+		if code, invalid := invalidCode(txn, block.Hash); invalid {
+			return detach, attach[i:], rejectf(code, "block %x is known to be invalid", block.Hash)
+		}
+
+		if err := connectBlock(txn, block); err != nil {
+			return detach, attach[i:], err
+		}
+	}
+
+	return detach, attach, txn.Set([]byte("lh"), newTip.Hash)
+}
@@ -0,0 +1,200 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+// buildBlock seals block with txs on top of parent, paying subsidy and fees to miner
+func buildBlock(t *testing.T, chain *Chain, parent *Block, miner string, fees int, txs ...*Transaction) *Block {
+	t.Helper()
+
+	var bits uint32
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		bits, err = chain.Engine.NextBits(txn, parent)
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := CoinbaseTx(miner, "", parent.Height+1, fees)
+
+	return CreateBlock(chain.Engine, append([]*Transaction{coinbase}, txs...), parent.Hash, parent.Height+1, bits, parent.Timestamp+1)
+}
+
+func genesisBlock(t *testing.T, chain *Chain) *Block {
+	t.Helper()
+
+	block, err := chain.GetBlock(chain.GenesisHash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &block
+}
+
+// owned sums spendable and immature outputs of address
+func owned(chain *Chain, address string) int {
+	spendable, immature := UTXOSet{Blockchain: chain}.FindBalance(LockScript(address))
+
+	return spendable + immature
+}
+
+func hashes(blocks []*Block) [][]byte {
+	var hashes [][]byte
+
+	for _, block := range blocks {
+		hashes = append(hashes, block.Hash)
+	}
+
+	return hashes
+}
+
+func sameBlocks(t *testing.T, name string, got []*Block, want ...*Block) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s blocks are %x, want %x", name, hashes(got), hashes(want))
+	}
+
+	for i := range got {
+		if bytes.Compare(got[i].Hash, want[i].Hash) != 0 {
+			t.Fatalf("%s blocks are %x, want %x", name, hashes(got), hashes(want))
+		}
+	}
+}
+
+// TestReorganize switches the main chain to a competing branch with more work
+// and checks that the UTXO set follows it
+func TestReorganize(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	minerA, minerB := walletAddress(wallet.MakeWallet()), walletAddress(wallet.MakeWallet())
+	chain := newTestChain(t, "reorg", alice, 100)
+	genesis := genesisBlock(t, chain)
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	send, err := NewTransaction(alice, walletAddress(bob), 30, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a1 := buildBlock(t, chain, genesis, minerA, 1, send)
+
+	_, connected, err := chain.ProcessBlock(a1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameBlocks(t, "connected", connected, a1)
+
+	if got := owned(chain, walletAddress(bob)); got != 30 {
+		t.Fatalf("bob owns %d on branch A, want 30", got)
+	}
+
+	b1 := buildBlock(t, chain, genesis, minerB, 0)
+	b2 := buildBlock(t, chain, b1, minerB, 0)
+
+	if _, connected, err := chain.ProcessBlock(b1); err != nil || len(connected) != 0 {
+		t.Fatalf("branch with equal work is connected %x, error %v", hashes(connected), err)
+	}
+
+	disconnected, connected, err := chain.ProcessBlock(b2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameBlocks(t, "disconnected", disconnected, a1)
+	sameBlocks(t, "connected", connected, b1, b2)
+
+	if tip := chain.Tip(); bytes.Compare(tip, b2.Hash) != 0 {
+		t.Fatalf("tip is %x, want %x", tip, b2.Hash)
+	}
+
+	owners := []struct {
+		name    string
+		address string
+		want    int
+	}{
+		{"alice", walletAddress(alice), 100},
+		{"bob", walletAddress(bob), 0},
+		{"miner A", minerA, 0},
+		{"miner B", minerB, 40},
+	}
+
+	for _, o := range owners {
+		if got := owned(chain, o.address); got != o.want {
+			t.Errorf("%s owns %d after reorganization, want %d", o.name, got, o.want)
+		}
+	}
+
+	if got, want := UTXOSet.TotalValue(), 140; got != want {
+		t.Errorf("UTXO set holds %d, want %d", got, want)
+	}
+}
+
+// TestReorganizeSkipsInvalidBlock connects a branch whose last block fails to connect:
+// the valid part of the branch stays connected and the invalid block is remembered
+func TestReorganizeSkipsInvalidBlock(t *testing.T) {
+	useRegTest(t)
+
+	miner := walletAddress(wallet.MakeWallet())
+	chain := newTestChain(t, "invalid", wallet.MakeWallet(), 100)
+	genesis := genesisBlock(t, chain)
+
+	b1 := buildBlock(t, chain, genesis, miner, 0)
+	// the coinbase claims fees the block does not have, which is only found connecting it
+	b2 := buildBlock(t, chain, b1, miner, 5)
+	b3 := buildBlock(t, chain, b2, miner, 0)
+
+	// b2 arrives first and waits for its parent as an orphan
+	if _, connected, err := chain.ProcessBlock(b2); err != nil || len(connected) != 0 {
+		t.Fatalf("orphan is connected %x, error %v", hashes(connected), err)
+	}
+
+	_, connected, err := chain.ProcessBlock(b1)
+
+	if err != nil {
+		t.Fatalf("valid parent of invalid block is rejected: %s", err)
+	}
+
+	sameBlocks(t, "connected", connected, b1)
+
+	if tip := chain.Tip(); bytes.Compare(tip, b1.Hash) != 0 {
+		t.Fatalf("tip is %x, want %x", tip, b1.Hash)
+	}
+
+	if got := owned(chain, miner); got != 20 {
+		t.Errorf("miner owns %d, want 20", got)
+	}
+
+	rejects := []struct {
+		name  string
+		block *Block
+		code  RejectCode
+	}{
+		{"invalid block", b2, RejectBadCoinbaseAmount},
+		{"child of invalid block", b3, RejectInvalidAncestor},
+	}
+
+	for _, r := range rejects {
+		err := chain.AddBlock(r.block)
+
+		if invalid, ok := err.(*ValidationError); !ok || invalid.Code != r.code {
+			t.Errorf("%s is rejected with %v, want %s", r.name, err, r.code)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// SecretSize is size of HTLC secret. It is fixed by the contract, so the secret
+// revealed on one chain is accepted by the contract on the other chain too
+const SecretSize = 32
+
+// HTLCScript locks output with hash time-locked contract. Recipient can spend
+// it revealing secret with SHA256 secretHash, refund owner can take it back after lockTime:
+//
+//	OP_IF
+//		OP_SIZE <SecretSize> OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY OP_DUP OP_HASH160 <recipient>
+//	OP_ELSE
+//		<lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <refund>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+//
+// it is redeemed by <signature> <pubKey> <secret> OP_1 and refunded by <signature> <pubKey> OP_0
+func HTLCScript(secretHash, recipient, refund []byte, lockTime int64) []byte {
+	return NewScriptBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).
+		AddInt(SecretSize).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA256).
+		AddData(secretHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(recipient).
+		AddOp(OP_ELSE).
+		AddInt(lockTime).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(refund).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// ParseHTLCScript returns secret hash, recipient and refund public key hashes and lock time
+// of script built with HTLCScript, ok is false for other scripts
+func ParseHTLCScript(script []byte) (secretHash, recipient, refund []byte, lockTime int64, ok bool) {
+	ops, err := ParseScript(script)
+
+	if err != nil || len(ops) != 20 {
+		return nil, nil, nil, 0, false
+	}
+
+	// rebuilding the script rejects any other opcode or encoding of data
+	if size, isNum := ops[2].number(maxScriptNumLen); !isNum || size != SecretSize {
+		return nil, nil, nil, 0, false
+	}
+
+	if lockTime, ok = ops[11].number(lockTimeNumLen); !ok {
+		return nil, nil, nil, 0, false
+	}
+
+	secretHash, recipient, refund = ops[5].Data, ops[9].Data, ops[16].Data
+
+	if bytes.Compare(HTLCScript(secretHash, recipient, refund, lockTime), script) != 0 {
+		return nil, nil, nil, 0, false
+	}
+
+	return secretHash, recipient, refund, lockTime, true
+}
+
+// NewHTLCRedeemTransaction sends all funds locked with contract to address to,
+// revealing secret. Key of w has to be the contract recipient
+func NewHTLCRedeemTransaction(w *wallet.Wallet, contract, secret []byte, to string, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	secretHash, recipient, _, _, ok := ParseHTLCScript(contract)
+
+	if !ok {
+		return nil, errors.New("script is not a hash time-locked contract")
+	}
+
+	if bytes.Compare(recipient, wallet.PublicHash(w.PublicKey)) != 0 {
+		return nil, errors.New("key is not the recipient of the contract")
+	}
+
+	hash := sha256.Sum256(secret)
+
+	if len(secret) != SecretSize || bytes.Compare(hash[:], secretHash) != 0 {
+		return nil, errors.New("secret does not match the contract hash")
+	}
+
+	tx, err := newHTLCSpend(contract, to, fee, UTXO)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for inId := range tx.Inputs {
+		signature, err := tx.signInput(w, inId, contract, SigHashAll)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tx.Inputs[inId].Script = NewScriptBuilder().
+			AddData(signature).
+			AddData(w.PublicKey).
+			AddData(secret).
+			AddOp(OP_1).
+			AddData(contract).
+			Script()
+	}
+
+	tx.ID = tx.Hash()
+
+	return tx, nil
+}
+
+// NewHTLCRefundTransaction sends all funds locked with contract back to address to
+// once its lock time is over. Key of w has to be the contract refund key
+func NewHTLCRefundTransaction(w *wallet.Wallet, contract []byte, to string, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	_, _, refund, lockTime, ok := ParseHTLCScript(contract)
+
+	if !ok {
+		return nil, errors.New("script is not a hash time-locked contract")
+	}
+
+	if bytes.Compare(refund, wallet.PublicHash(w.PublicKey)) != 0 {
+		return nil, errors.New("key can't refund the contract")
+	}
+
+	tx, err := newHTLCSpend(contract, to, fee, UTXO)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx.LockTime = uint32(lockTime)
+
+	for inId := range tx.Inputs {
+		// any sequence below MaxSequence enables lock time
+		tx.Inputs[inId].Sequence = MaxSequence - 1
+	}
+
+	for inId := range tx.Inputs {
+		signature, err := tx.signInput(w, inId, contract, SigHashAll)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tx.Inputs[inId].Script = NewScriptBuilder().
+			AddData(signature).
+			AddData(w.PublicKey).
+			AddOp(OP_0).
+			AddData(contract).
+			Script()
+	}
+
+	tx.ID = tx.Hash()
+
+	return tx, nil
+}
+
+// newHTLCSpend creates unsigned transaction sending the whole contract balance less fee
+func newHTLCSpend(contract []byte, to string, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	address := fmt.Sprintf("%s", wallet.ScriptAddress(contract))
+	balance, _ := UTXO.FindBalance(LockScript(address))
+
+	if balance <= fee {
+		return nil, fmt.Errorf("contract %s holds %d, not enough to pay fee %d", address, balance, fee)
+	}
+
+	return newSpend(address, to, balance-fee, fee, UTXO)
+}
+
+// ExtractHTLCSecret returns secret revealed by transaction redeeming contract
+func ExtractHTLCSecret(tx *Transaction, contract []byte) ([]byte, bool) {
+	secretHash, _, _, _, ok := ParseHTLCScript(contract)
+
+	if !ok || tx.IsCoinbase() {
+		return nil, false
+	}
+
+	for _, in := range tx.Inputs {
+		ops, err := ParseScript(in.Script)
+
+		if err != nil || len(ops) != 5 || bytes.Compare(ops[4].Data, contract) != 0 {
+			continue
+		}
+
+		secret := ops[2].Data
+		hash := sha256.Sum256(secret)
+
+		if bytes.Compare(hash[:], secretHash) == 0 {
+			return secret, true
+		}
+	}
+
+	return nil, false
+}
+
+// FindHTLCSecret searches the chain for transaction redeeming contract
+// and returns the secret it reveals
+func (c *Chain) FindHTLCSecret(contract []byte) ([]byte, error) {
+	iter := c.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if secret, ok := ExtractHTLCSecret(tx, contract); ok {
+				return secret, nil
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, errors.New("contract is not redeemed")
+}
+
+// FindPublicKey searches the chain for input revealing public key with hash pubKeyHash.
+// Contracts commit to key hashes only, so this is how type of a foreign key is learned
+func (c *Chain) FindPublicKey(pubKeyHash []byte) ([]byte, bool) {
+	iter := c.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+
+			for _, in := range tx.Inputs {
+				ops, err := ParseScript(in.Script)
+
+				if err != nil {
+					continue
+				}
+
+				for _, op := range ops {
+					if _, isKey := wallet.PublicKeyType(op.Data); isKey && bytes.Compare(wallet.PublicHash(op.Data), pubKeyHash) == 0 {
+						return op.Data, true
+					}
+				}
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// useRegTest makes regtest active for the test, chains are kept in a temporary directory
+func useRegTest(t *testing.T) {
+	params := chaincfg.RegTestParams
+	params.DataDir = t.TempDir()
+
+	previous := chaincfg.Active
+	chaincfg.Active = &params
+
+	t.Cleanup(func() { chaincfg.Active = previous })
+}
+
+// newTestChain creates chain of node premining amount to owner
+func newTestChain(t *testing.T, nodeId string, owner *wallet.Wallet, amount int) *Chain {
+	genesis := &chaincfg.Genesis{Alloc: []chaincfg.GenesisAlloc{{Address: walletAddress(owner), Amount: amount}}}
+	chain := InitBlockChain(nodeId, ConsensusConfig{Engine: PoWEngine}, genesis)
+
+	t.Cleanup(func() { chain.Database.Close() })
+
+	return chain
+}
+
+func walletAddress(w *wallet.Wallet) string {
+	return fmt.Sprintf("%s", w.Address())
+}
+
+func balance(chain *Chain, address string) int {
+	spendable, _ := UTXOSet{Blockchain: chain}.FindBalance(LockScript(address))
+
+	return spendable
+}
+
+// mineTx mines tx in the next block, paying subsidy and fee to miner
+func mineTx(t *testing.T, chain *Chain, miner string, tx *Transaction) {
+	t.Helper()
+
+	if err := chain.CheckLockTimes(tx); err != nil {
+		t.Fatalf("transaction %x can't be mined: %s", tx.ID, err)
+	}
+
+	prevOuts, err := UTXOSet{Blockchain: chain}.FindOutputs(tx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fee, err := Fee(tx, prevOuts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := CoinbaseTx(miner, "", chain.GetBestHeight()+1, fee)
+
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, tx}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lockHTLC locks amount of w on chain in contract paying recipient for secret,
+// w can take it back from block lockTime
+func lockHTLC(t *testing.T, chain *Chain, w, recipient *wallet.Wallet, secretHash []byte, lockTime int64, amount int, miner string) []byte {
+	t.Helper()
+
+	contract := HTLCScript(secretHash, wallet.PublicHash(recipient.PublicKey), wallet.PublicHash(w.PublicKey), lockTime)
+	contractAddress := fmt.Sprintf("%s", wallet.ScriptAddress(contract))
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewTransaction(w, contractAddress, amount, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, tx)
+
+	if got := balance(chain, contractAddress); got != amount {
+		t.Fatalf("contract holds %d, want %d", got, amount)
+	}
+
+	return contract
+}
+
+// TestAtomicSwap swaps coins of alice on one chain for coins of bob on another one:
+// alice locks coins for bob with hash of her secret, bob locks coins for alice with the same hash,
+// alice takes coins of bob revealing the secret and bob uses it to take coins of alice
+func TestAtomicSwap(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob, miner := wallet.MakeWallet(), wallet.MakeWallet(), walletAddress(wallet.MakeWallet())
+	chainA := newTestChain(t, "swap_a", alice, 100)
+	chainB := newTestChain(t, "swap_b", bob, 100)
+
+	secret := make([]byte, SecretSize)
+	copy(secret, "secret of alice")
+	secretHash := sha256.Sum256(secret)
+
+	// bob's contract expires first, so alice can't wait for his refund to take both
+	contractA := lockHTLC(t, chainA, alice, bob, secretHash[:], 20, 50, miner)
+	contractB := lockHTLC(t, chainB, bob, alice, secretHash[:], 10, 40, miner)
+
+	UTXOB := UTXOSet{Blockchain: chainB}
+
+	if _, err := NewHTLCRedeemTransaction(alice, contractB, make([]byte, SecretSize), walletAddress(alice), 1, &UTXOB); err == nil {
+		t.Fatal("contract is redeemed with wrong secret")
+	}
+
+	if _, err := NewHTLCRedeemTransaction(bob, contractB, secret, walletAddress(bob), 1, &UTXOB); err == nil {
+		t.Fatal("contract is redeemed by key which is not its recipient")
+	}
+
+	redeemB, err := NewHTLCRedeemTransaction(alice, contractB, secret, walletAddress(alice), 1, &UTXOB)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chainB, miner, redeemB)
+
+	revealed, err := chainB.FindHTLCSecret(contractB)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	UTXOA := UTXOSet{Blockchain: chainA}
+	redeemA, err := NewHTLCRedeemTransaction(bob, contractA, revealed, walletAddress(bob), 1, &UTXOA)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chainA, miner, redeemA)
+
+	balances := []struct {
+		name  string
+		chain *Chain
+		owner string
+		want  int
+	}{
+		{"alice on chain A", chainA, walletAddress(alice), 49},
+		{"bob on chain A", chainA, walletAddress(bob), 49},
+		{"alice on chain B", chainB, walletAddress(alice), 39},
+		{"bob on chain B", chainB, walletAddress(bob), 59},
+	}
+
+	for _, b := range balances {
+		if got := balance(b.chain, b.owner); got != b.want {
+			t.Errorf("balance of %s is %d, want %d", b.name, got, b.want)
+		}
+	}
+}
+
+// TestAtomicSwapRefund takes coins back once the counterparty does not redeem them before lock time
+func TestAtomicSwapRefund(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob, miner := wallet.MakeWallet(), wallet.MakeWallet(), walletAddress(wallet.MakeWallet())
+	chain := newTestChain(t, "refund", alice, 100)
+
+	secretHash := sha256.Sum256(make([]byte, SecretSize))
+	lockTime := int64(10)
+	contract := lockHTLC(t, chain, alice, bob, secretHash[:], lockTime, 50, miner)
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+
+	if _, err := NewHTLCRefundTransaction(bob, contract, walletAddress(bob), 1, &UTXOSet); err == nil {
+		t.Fatal("contract is refunded by key which is not its refund key")
+	}
+
+	refund, err := NewHTLCRefundTransaction(alice, contract, walletAddress(alice), 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.CheckLockTimes(refund); err == nil {
+		t.Fatalf("contract is refunded at height %d before lock time %d", chain.GetBestHeight()+1, lockTime)
+	}
+
+	// lock time is the last height at which the contract can't be refunded yet
+	if _, err := chain.Generate(context.Background(), int(lockTime)-chain.GetBestHeight(), miner); err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, refund)
+
+	if got := balance(chain, walletAddress(alice)); got != 98 {
+		t.Errorf("balance of alice is %d, want 98", got)
+	}
+
+	contractAddress := fmt.Sprintf("%s", wallet.ScriptAddress(contract))
+
+	if got := balance(chain, contractAddress); got != 0 {
+		t.Errorf("contract holds %d after refund", got)
+	}
+}
+
+// TestFindPublicKey learns type of contract key from the chain once the key spends coins
+func TestFindPublicKey(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob, miner := wallet.MakeWalletOfType(wallet.Ed25519), wallet.MakeWallet(), walletAddress(wallet.MakeWallet())
+	chain := newTestChain(t, "pubkey", alice, 100)
+
+	if _, found := chain.FindPublicKey(wallet.PublicHash(alice.PublicKey)); found {
+		t.Fatal("key which has not spent anything is found")
+	}
+
+	secretHash := sha256.Sum256(make([]byte, SecretSize))
+	lockHTLC(t, chain, alice, bob, secretHash[:], 10, 50, miner)
+
+	pubKey, found := chain.FindPublicKey(wallet.PublicHash(alice.PublicKey))
+
+	if !found || bytes.Compare(pubKey, alice.PublicKey) != 0 {
+		t.Fatalf("key of alice is found as %x, want %x", pubKey, alice.PublicKey)
+	}
+
+	if keyType, _ := wallet.PublicKeyType(pubKey); keyType != wallet.Ed25519 {
+		t.Errorf("key of alice is %s", keyType)
+	}
+
+	if _, found := chain.FindPublicKey(wallet.PublicHash(bob.PublicKey)); found {
+		t.Error("key of bob is found before bob spends anything")
+	}
+}
@@ -0,0 +1,660 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+const (
+	// MaxScriptSize is the longest script which can be run
+	MaxScriptSize = 10000
+	// MaxScriptElementSize is the longest data which can be pushed to the stack
+	MaxScriptElementSize = 520
+	// MaxStackSize is the most items stack can hold
+	MaxStackSize = 1000
+	// MaxScriptOps is the most non-push opcodes single script can run
+	MaxScriptOps = 201
+)
+
+var errScriptFalse = errors.New("script evaluated to false")
+
+// interpreter runs scripts of a single transaction input
+type interpreter struct {
+	tx *Transaction
+	// index of the input being verified
+	index int
+	// lockScript is the script of spent output, it is what signatures commit to
+	lockScript []byte
+	stack      [][]byte
+	// conditions holds whether every enclosing OP_IF branch is executed
+	conditions []bool
+}
+
+// VerifyScript checks that unlocking script of tx.Inputs[index] unlocks prevOut.
+// When prevOut is locked with P2SHScript, the last item pushed by unlocking script
+// is the script itself and it is run on the rest of pushed items as well
+func VerifyScript(tx *Transaction, index int, prevOut TxOutput) error {
+	vm := &interpreter{tx: tx, index: index, lockScript: prevOut.Script}
+	unlockScript := tx.Inputs[index].Script
+
+	// only data can be pushed by unlocking script, otherwise it could
+	// be changed by anyone without invalidating the signatures
+	ops, err := ParseScript(unlockScript)
+
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if !op.isPush() {
+			return fmt.Errorf("unlocking script runs %s, only pushes are allowed", op.Opcode)
+		}
+	}
+
+	if err := vm.run(unlockScript); err != nil {
+		return err
+	}
+
+	pushed := append([][]byte{}, vm.stack...)
+
+	if err := vm.run(prevOut.Script); err != nil {
+		return err
+	}
+
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return errScriptFalse
+	}
+
+	if ExtractScriptHash(prevOut.Script) == nil {
+		return nil
+	}
+
+	// script hash matches, so the script can be run in place of the output script
+	vm.stack = pushed
+	redeemScript, err := vm.pop()
+
+	if err != nil {
+		return err
+	}
+
+	vm.lockScript = redeemScript
+
+	if err := vm.run(redeemScript); err != nil {
+		return err
+	}
+
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return errScriptFalse
+	}
+
+	return nil
+}
+
+func (vm *interpreter) executing() bool {
+	for _, condition := range vm.conditions {
+		if !condition {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (vm *interpreter) push(data []byte) error {
+	if len(data) > MaxScriptElementSize {
+		return fmt.Errorf("pushed data is longer than %d bytes", MaxScriptElementSize)
+	}
+
+	if len(vm.stack) >= MaxStackSize {
+		return fmt.Errorf("stack has more than %d items", MaxStackSize)
+	}
+
+	vm.stack = append(vm.stack, data)
+
+	return nil
+}
+
+func (vm *interpreter) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("stack is empty")
+	}
+
+	data := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return data, nil
+}
+
+func (vm *interpreter) pushBool(value bool) error {
+	if value {
+		return vm.push([]byte{1})
+	}
+
+	return vm.push(nil)
+}
+
+// verify pops item and requires it to be true, which is what *VERIFY opcodes do
+func (vm *interpreter) verify(op Opcode) error {
+	top, err := vm.pop()
+
+	if err != nil {
+		return err
+	}
+
+	if !asBool(top) {
+		return fmt.Errorf("%s failed", op)
+	}
+
+	return nil
+}
+
+func (vm *interpreter) run(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("script is longer than %d bytes", MaxScriptSize)
+	}
+
+	ops, err := ParseScript(script)
+
+	if err != nil {
+		return err
+	}
+
+	opCount := 0
+	vm.conditions = nil
+
+	for _, op := range ops {
+		if op.Opcode > OP_16 {
+			if opCount++; opCount > MaxScriptOps {
+				return fmt.Errorf("script runs more than %d opcodes", MaxScriptOps)
+			}
+		}
+
+		if err := vm.step(op); err != nil {
+			return err
+		}
+	}
+
+	if len(vm.conditions) != 0 {
+		return errors.New("OP_IF is not closed with OP_ENDIF")
+	}
+
+	return nil
+}
+
+func (vm *interpreter) step(op ScriptOp) error {
+	switch op.Opcode {
+	case OP_IF, OP_NOTIF:
+		value := false
+
+		if vm.executing() {
+			top, err := vm.pop()
+
+			if err != nil {
+				return err
+			}
+
+			value = asBool(top) == (op.Opcode == OP_IF)
+		}
+
+		vm.conditions = append(vm.conditions, value)
+
+		return nil
+	case OP_ELSE:
+		if len(vm.conditions) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+
+		last := len(vm.conditions) - 1
+		vm.conditions[last] = !vm.conditions[last]
+
+		return nil
+	case OP_ENDIF:
+		if len(vm.conditions) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+
+		vm.conditions = vm.conditions[:len(vm.conditions)-1]
+
+		return nil
+	}
+
+	if !vm.executing() {
+		return nil
+	}
+
+	switch {
+	case op.Opcode == OP_0 || op.hasData():
+		return vm.push(op.Data)
+	case op.Opcode == OP_1NEGATE:
+		return vm.push(encodeScriptNum(-1))
+	case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+		return vm.push(encodeScriptNum(int64(op.Opcode - OP_1 + 1)))
+	}
+
+	switch op.Opcode {
+	case OP_NOP:
+		return nil
+	case OP_VERIFY:
+		return vm.verify(op.Opcode)
+	case OP_RETURN:
+		return errors.New("OP_RETURN output can not be spent")
+	case OP_DROP:
+		_, err := vm.pop()
+
+		return err
+	case OP_DUP:
+		top, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		vm.stack = append(vm.stack, top)
+
+		return vm.push(top)
+	case OP_SWAP:
+		a, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		b, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		vm.stack = append(vm.stack, a)
+
+		return vm.push(b)
+	case OP_SIZE:
+		if len(vm.stack) == 0 {
+			return errors.New("stack is empty")
+		}
+
+		return vm.push(encodeScriptNum(int64(len(vm.stack[len(vm.stack)-1]))))
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		b, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		if err := vm.pushBool(bytes.Equal(a, b)); err != nil {
+			return err
+		}
+
+		if op.Opcode == OP_EQUALVERIFY {
+			return vm.verify(op.Opcode)
+		}
+
+		return nil
+	case OP_SHA256:
+		top, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(top)
+
+		return vm.push(hash[:])
+	case OP_HASH160:
+		top, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		return vm.push(wallet.PublicHash(top))
+	case OP_CHECKLOCKTIMEVERIFY:
+		return vm.checkLockTime()
+	case OP_CHECKSEQUENCEVERIFY:
+		return vm.checkSequence()
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := vm.checkMultisig()
+
+		if err != nil {
+			return err
+		}
+
+		if err := vm.pushBool(valid); err != nil {
+			return err
+		}
+
+		if op.Opcode == OP_CHECKMULTISIGVERIFY {
+			return vm.verify(op.Opcode)
+		}
+
+		return nil
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		signature, err := vm.pop()
+
+		if err != nil {
+			return err
+		}
+
+		if err := checkTxSignatureEncoding(signature, pubKey); err != nil {
+			return err
+		}
+
+		valid := vm.checkTxSignature(signature, pubKey)
+
+		if err := vm.pushBool(valid); err != nil {
+			return err
+		}
+
+		if op.Opcode == OP_CHECKSIGVERIFY {
+			return vm.verify(op.Opcode)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%s is not supported", op.Opcode)
+}
+
+func (vm *interpreter) popInt() (int64, error) {
+	data, err := vm.pop()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeScriptNum(data, maxScriptNumLen)
+}
+
+// peekLockTime reads lock time from the stack top without removing it,
+// so script can go on with the rest of the checks after OP_DROP
+func (vm *interpreter) peekLockTime() (int64, error) {
+	if len(vm.stack) == 0 {
+		return 0, errors.New("stack is empty")
+	}
+
+	lockTime, err := decodeScriptNum(vm.stack[len(vm.stack)-1], lockTimeNumLen)
+
+	if err == nil && lockTime < 0 {
+		err = errors.New("lock time is negative")
+	}
+
+	return lockTime, err
+}
+
+// checkLockTime requires transaction lock time to be at least lockTime of the same kind,
+// both heights or both times, which holds only for final transactions
+func (vm *interpreter) checkLockTime() error {
+	lockTime, err := vm.peekLockTime()
+
+	if err != nil {
+		return err
+	}
+
+	txLockTime := int64(vm.tx.LockTime)
+
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return errors.New("lock time kind does not match transaction lock time")
+	}
+
+	if lockTime > txLockTime {
+		return fmt.Errorf("transaction lock time %d is before %d", txLockTime, lockTime)
+	}
+
+	// lock time is ignored when input is final
+	if vm.tx.Inputs[vm.index].Sequence == MaxSequence {
+		return errors.New("input sequence disables lock time")
+	}
+
+	return nil
+}
+
+// checkSequence requires relative lock of the input to be at least sequence of the same kind
+func (vm *interpreter) checkSequence() error {
+	lock, err := vm.peekLockTime()
+
+	if err != nil {
+		return err
+	}
+
+	sequence := uint32(lock)
+
+	if sequence&SequenceLockDisableFlag != 0 {
+		return nil
+	}
+
+	inSequence := vm.tx.Inputs[vm.index].Sequence
+
+	if inSequence&SequenceLockDisableFlag != 0 {
+		return errors.New("input relative lock is disabled")
+	}
+
+	if sequence&SequenceLockTimeFlag != inSequence&SequenceLockTimeFlag {
+		return errors.New("relative lock kind does not match input sequence")
+	}
+
+	if sequence&SequenceLockMask > inSequence&SequenceLockMask {
+		return fmt.Errorf("input relative lock %d is shorter than %d", inSequence&SequenceLockMask, sequence&SequenceLockMask)
+	}
+
+	return nil
+}
+
+// checkMultisig pops n, n keys, m and m signatures. Signatures have to be
+// in the same order as keys, so every key is tried at most once
+func (vm *interpreter) checkMultisig() (bool, error) {
+	n, err := vm.popInt()
+
+	if err != nil {
+		return false, err
+	}
+
+	if n < 0 || n > MaxMultisigKeys {
+		return false, fmt.Errorf("multisig key count %d is out of range", n)
+	}
+
+	pubKeys := make([][]byte, n)
+
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := vm.popInt()
+
+	if err != nil {
+		return false, err
+	}
+
+	if m < 0 || m > n {
+		return false, fmt.Errorf("multisig signature count %d is out of range", m)
+	}
+
+	signatures := make([][]byte, m)
+
+	for i := m - 1; i >= 0; i-- {
+		if signatures[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+
+		// keys of signatures are not known yet, so only what is common to all key types is checked,
+		// non-canonical signatures don't verify against any key
+		if err := checkTxSignatureEncoding(signatures[i], nil); err != nil {
+			return false, err
+		}
+	}
+
+	key := 0
+
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !vm.checkTxSignature(signature, pubKeys[key]) {
+			key++
+		}
+
+		if key == len(pubKeys) {
+			return false, nil
+		}
+
+		key++
+	}
+
+	return true, nil
+}
+
+// checkTxSignature verifies signature of the input followed by its hash type, see SignatureHash
+func (vm *interpreter) checkTxSignature(signature, pubKey []byte) bool {
+	if len(signature) == 0 {
+		return false
+	}
+
+	hashType := SigHashType(signature[len(signature)-1])
+	hash, err := vm.tx.SignatureHash(vm.index, vm.lockScript, hashType)
+
+	if err != nil {
+		return false
+	}
+
+	return checkSignature(signature[:len(signature)-1], pubKey, hash)
+}
+
+// asBool treats empty string, zeros and negative zero as false
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// signatureLength is length of signature, r and s are 32 bytes each,
+// Ed25519 signatures are the same length
+const signatureLength = 64
+
+// halfOrder is half of the curve order. Whenever (r, s) is a valid signature, so is (r, N-s),
+// only the one with s not above halfOrder is accepted, so signatures can't be altered by third parties
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// signHash signs hash with key of w. P256 signature r and s are padded to 32 bytes
+// so signature can be split in halves, s is taken from the lower half of the curve order
+func signHash(w *wallet.Wallet, hash []byte) ([]byte, error) {
+	if w.Type() == wallet.Ed25519 {
+		return ed25519.Sign(w.EdPrivateKey, hash), nil
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &w.PrivateKey, hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(elliptic.P256().Params().N, s)
+	}
+
+	signature := make([]byte, signatureLength)
+	r.FillBytes(signature[:signatureLength/2])
+	s.FillBytes(signature[signatureLength/2:])
+
+	return signature, nil
+}
+
+// checkSignatureEncoding fails script on signature which is neither empty nor canonical
+// for pubKey: 64 bytes, for P256 keys r and s, both in range of the curve order, with low s.
+// Ed25519 signatures are checked to be canonical when verified, nil pubKey checks length only
+func checkSignatureEncoding(signature, pubKey []byte) error {
+	if len(signature) == 0 {
+		return nil
+	}
+
+	if len(signature) != signatureLength {
+		return fmt.Errorf("signature is %d bytes, expected %d", len(signature), signatureLength)
+	}
+
+	if keyType, ok := wallet.PublicKeyType(pubKey); !ok || keyType != wallet.P256 {
+		return nil
+	}
+
+	r := new(big.Int).SetBytes(signature[:signatureLength/2])
+	s := new(big.Int).SetBytes(signature[signatureLength/2:])
+
+	if r.Sign() == 0 || r.Cmp(elliptic.P256().Params().N) >= 0 || s.Sign() == 0 {
+		return errors.New("signature r or s is out of range")
+	}
+
+	if s.Cmp(halfOrder) > 0 {
+		return errors.New("signature s is not low")
+	}
+
+	return nil
+}
+
+// checkTxSignatureEncoding fails script on transaction signature which is neither empty
+// nor canonical signature for pubKey followed by valid hash type
+func checkTxSignatureEncoding(signature, pubKey []byte) error {
+	if len(signature) == 0 {
+		return nil
+	}
+
+	if len(signature) != signatureLength+1 {
+		return fmt.Errorf("signature is %d bytes, expected %d with hash type", len(signature), signatureLength+1)
+	}
+
+	if hashType := SigHashType(signature[signatureLength]); !hashType.isValid() {
+		return fmt.Errorf("signature hash type %02x is not valid", byte(hashType))
+	}
+
+	return checkSignatureEncoding(signature[:signatureLength], pubKey)
+}
+
+// checkSignature verifies canonical signature of hash by public key, which is
+// either X and Y of P256 point or Ed25519 key, told apart by length
+func checkSignature(signature, pubKey, hash []byte) bool {
+	keyType, ok := wallet.PublicKeyType(pubKey)
+
+	if !ok || len(signature) == 0 || checkSignatureEncoding(signature, pubKey) != nil {
+		return false
+	}
+
+	if keyType == wallet.Ed25519 {
+		return ed25519.Verify(pubKey, hash, signature)
+	}
+
+	r := new(big.Int).SetBytes(signature[:signatureLength/2])
+	s := new(big.Int).SetBytes(signature[signatureLength/2:])
+
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+
+	curve := elliptic.P256()
+
+	if !curve.IsOnCurve(x, y) {
+		return false
+	}
+
+	key := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	return ecdsa.Verify(&key, hash, r, s)
+}
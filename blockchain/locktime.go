@@ -0,0 +1,194 @@
+package blockchain
+
+import (
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// LockTimeThreshold separates lock time given as block height from lock time given as unix time
+	LockTimeThreshold = 500000000
+	// MaxSequence is sequence of input without relative lock, when all inputs have it
+	// transaction lock time is not checked either
+	MaxSequence uint32 = 0xffffffff
+
+	// Relative lock of input is its sequence when SequenceLockDisableFlag is not set.
+	// The lowest 16 bits are number of blocks output has to be buried under before it can be spent,
+	// with SequenceLockTimeFlag it is number of 512 second intervals instead
+	SequenceLockDisableFlag uint32 = 1 << 31
+	SequenceLockTimeFlag    uint32 = 1 << 22
+	SequenceLockMask        uint32 = 0x0000ffff
+	// SequenceLockGranularity is log2 of the time interval relative lock counts
+	SequenceLockGranularity = 9
+
+	// Input with sequence below MaxSequence-1 signals that transaction may be replaced in memory pools
+	// by a conflicting one paying higher fee, ReplaceableSequence is the highest such sequence
+	ReplaceableSequence = MaxSequence - 2
+)
+
+// IsFinal reports whether lock time allows transaction in block at height.
+// Time lock is compared with median time past of the previous block, which unlike
+// block own time can't be chosen by miner
+func (tx *Transaction) IsFinal(height int, pastMedian int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	cutoff := int64(height)
+
+	if tx.LockTime >= LockTimeThreshold {
+		cutoff = pastMedian
+	}
+
+	if int64(tx.LockTime) < cutoff {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Sequence != MaxSequence {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SignalsReplacement reports whether transaction opts in to be replaced
+// by a conflicting transaction until it is mined
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, in := range tx.Inputs {
+		if in.Sequence <= ReplaceableSequence {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ancestorAt returns block of the branch ending with block at height
+func ancestorAt(txn *badger.Txn, block *Block, height int) (*Block, error) {
+	for block.Height > height {
+		var err error
+
+		if block, err = getBlock(txn, block.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+
+	return block, nil
+}
+
+// validateLockTimes checks lock time and relative locks of tx spending outputs
+// created at coinHeights, when tx is included in block following parent
+func validateLockTimes(txn *badger.Txn, tx *Transaction, coinHeights []int, parent *Block) error {
+	height := parent.Height + 1
+	pastMedian, err := medianTimePast(txn, parent)
+
+	if err != nil {
+		return err
+	}
+
+	if !tx.IsFinal(height, pastMedian) {
+		return rejectf(RejectNonFinal, "transaction %x is locked until %d", tx.ID, tx.LockTime)
+	}
+
+	// the last height and time at which the transaction is still locked
+	minHeight, minTime := -1, int64(-1)
+
+	for i, in := range tx.Inputs {
+		if in.Sequence&SequenceLockDisableFlag != 0 {
+			continue
+		}
+
+		lock := int64(in.Sequence & SequenceLockMask)
+
+		if in.Sequence&SequenceLockTimeFlag == 0 {
+			if lockedUntil := coinHeights[i] + int(lock) - 1; lockedUntil > minHeight {
+				minHeight = lockedUntil
+			}
+
+			continue
+		}
+
+		// time is counted from median time past of the block before the one with output
+		coinBlockHeight := coinHeights[i] - 1
+
+		if coinBlockHeight < 0 {
+			coinBlockHeight = 0
+		}
+
+		coinBlock, err := ancestorAt(txn, parent, coinBlockHeight)
+
+		if err != nil {
+			return err
+		}
+
+		coinTime, err := medianTimePast(txn, coinBlock)
+
+		if err != nil {
+			return err
+		}
+
+		if lockedUntil := coinTime + lock<<SequenceLockGranularity - 1; lockedUntil > minTime {
+			minTime = lockedUntil
+		}
+	}
+
+	if minHeight >= height || minTime >= pastMedian {
+		return rejectf(RejectSequenceLock, "transaction %x spends outputs which are relatively locked", tx.ID)
+	}
+
+	return nil
+}
+
+// CheckLockTimes checks that transaction lock time and relative locks
+// allow it in the next block of the main chain. Outputs which are not in the UTXO set
+// are taken as outputs of unconfirmed transactions, which can be mined in the next block
+// at the earliest, whether they exist at all has to be checked separately
+func (c *Chain) CheckLockTimes(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	return c.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("lh"))
+
+		if err != nil {
+			return err
+		}
+
+		lastHash, err := item.ValueCopy(nil)
+
+		if err != nil {
+			return err
+		}
+
+		tip, err := getBlock(txn, lastHash)
+
+		if err != nil {
+			return err
+		}
+
+		var coinHeights []int
+
+		for _, in := range tx.Inputs {
+			item, err := txn.Get(prefixedKey(utxoPrefix, in.ID))
+
+			if err == badger.ErrKeyNotFound {
+				coinHeights = append(coinHeights, tip.Height+1)
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			v, err := item.ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			coinHeights = append(coinHeights, DeserializeOutputs(v).Height)
+		}
+
+		return validateLockTimes(txn, tx, coinHeights, tip)
+	})
+}
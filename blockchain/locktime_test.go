@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestIsFinal(t *testing.T) {
+	const now = 1704067200
+
+	txs := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		final    bool
+	}{
+		{"no lock time", 0, 0, true},
+		{"height passed", 9, 0, true},
+		{"height reached", 10, 0, false},
+		{"height ahead", 11, 0, false},
+		{"height ahead with final sequence", 11, MaxSequence, true},
+		{"time passed", now - 1, 0, true},
+		{"time reached", now, 0, false},
+	}
+
+	for _, tx := range txs {
+		transaction := &Transaction{Inputs: []TxInput{{[]byte{0x01}, 0, nil, tx.sequence}}, LockTime: tx.lockTime}
+
+		if got := transaction.IsFinal(10, now); got != tx.final {
+			t.Errorf("%s: transaction at height 10 is final: %t, want %t", tx.name, got, tx.final)
+		}
+	}
+}
+
+// lockTimelock sends amount of w to address of timelock script, which w can spend once lock is over
+func lockTimelock(t *testing.T, chain *Chain, w *wallet.Wallet, op Opcode, lock int64, amount int, miner string) []byte {
+	t.Helper()
+
+	redeemScript := TimelockScript(op, lock, wallet.PublicHash(w.PublicKey))
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewTransaction(w, fmt.Sprintf("%s", wallet.ScriptAddress(redeemScript)), amount, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, tx)
+
+	return redeemScript
+}
+
+// spendTimelock checks that timelocked output can be spent in block at height and not before
+func spendTimelock(t *testing.T, chain *Chain, w *wallet.Wallet, redeemScript []byte, height int, code RejectCode, miner string) {
+	t.Helper()
+
+	recipient := walletAddress(wallet.MakeWallet())
+	UTXOSet := UTXOSet{Blockchain: chain}
+	spend, err := NewTimelockTransaction(w, redeemScript, recipient, 20, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blocks := height - 2 - chain.GetBestHeight(); blocks > 0 {
+		if _, err := chain.Generate(context.Background(), blocks, miner); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := chain.CheckLockTimes(spend); !rejected(err, code) {
+		t.Fatalf("locked spend is checked with error %v, want %s", err, code)
+	}
+
+	coinbase := CoinbaseTx(miner, "", chain.GetBestHeight()+1, 1)
+
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, spend}); !rejected(err, code) {
+		t.Fatalf("locked spend is mined with error %v, want %s", err, code)
+	}
+
+	if _, err := chain.Generate(context.Background(), 1, miner); err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, spend)
+
+	if got := balance(chain, recipient); got != 20 {
+		t.Errorf("recipient balance is %d, want 20", got)
+	}
+}
+
+func TestCheckLockTimeVerify(t *testing.T) {
+	useRegTest(t)
+
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, "cltv", owner, 100)
+	miner := walletAddress(wallet.MakeWallet())
+
+	// spend with lock time 5 is final in block 6
+	redeemScript := lockTimelock(t, chain, owner, OP_CHECKLOCKTIMEVERIFY, 5, 30, miner)
+	spendTimelock(t, chain, owner, redeemScript, 6, RejectNonFinal, miner)
+}
+
+func TestCheckSequenceVerify(t *testing.T) {
+	useRegTest(t)
+
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, "csv", owner, 100)
+	miner := walletAddress(wallet.MakeWallet())
+
+	// output of block 1 is buried under 3 blocks in block 4
+	redeemScript := lockTimelock(t, chain, owner, OP_CHECKSEQUENCEVERIFY, 3, 30, miner)
+	spendTimelock(t, chain, owner, redeemScript, 4, RejectSequenceLock, miner)
+}
+
+// TestTimelockScriptLock spends timelocked output with lock time or sequence below the script lock
+func TestTimelockScriptLock(t *testing.T) {
+	owner := wallet.MakeWallet()
+
+	locks := []struct {
+		name     string
+		op       Opcode
+		lockTime uint32
+		sequence uint32
+		valid    bool
+	}{
+		{"lock time reached", OP_CHECKLOCKTIMEVERIFY, 100, MaxSequence - 1, true},
+		{"lock time too early", OP_CHECKLOCKTIMEVERIFY, 99, MaxSequence - 1, false},
+		{"lock time of other kind", OP_CHECKLOCKTIMEVERIFY, LockTimeThreshold + 100, MaxSequence - 1, false},
+		{"final sequence", OP_CHECKLOCKTIMEVERIFY, 100, MaxSequence, false},
+		{"relative lock reached", OP_CHECKSEQUENCEVERIFY, 0, 100, true},
+		{"relative lock too short", OP_CHECKSEQUENCEVERIFY, 0, 99, false},
+		{"relative lock of other kind", OP_CHECKSEQUENCEVERIFY, 0, 100 | SequenceLockTimeFlag, false},
+		{"relative lock disabled", OP_CHECKSEQUENCEVERIFY, 0, MaxSequence, false},
+	}
+
+	for _, l := range locks {
+		redeemScript := TimelockScript(l.op, 100, wallet.PublicHash(owner.PublicKey))
+		prevOut := TxOutput{Value: 10, Script: P2SHScript(wallet.PublicHash(redeemScript))}
+
+		tx := &Transaction{
+			Inputs:   []TxInput{{[]byte{0x01}, 0, nil, l.sequence}},
+			Outputs:  []TxOutput{*NewTXOutput(10, walletAddress(owner))},
+			LockTime: l.lockTime,
+		}
+
+		signature, err := tx.signInput(owner, 0, redeemScript, SigHashAll)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx.Inputs[0].Script = NewScriptBuilder().AddData(signature).AddData(owner.PublicKey).AddData(redeemScript).Script()
+		err = VerifyScript(tx, 0, prevOut)
+
+		if l.valid && err != nil {
+			t.Errorf("%s: spend is rejected: %s", l.name, err)
+		}
+
+		if !l.valid && err == nil {
+			t.Errorf("%s: spend is verified", l.name)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package blockchain
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
 
 type MerkleTree struct {
 	RootNode *MerkleNode
@@ -12,6 +16,13 @@ type MerkleNode struct {
 	Data  []byte
 }
 
+// MerkleProofStep is a sibling hash on the way from leaf to the root.
+// Left is set when sibling is the left child, so it goes first when hashing
+type MerkleProofStep struct {
+	Hash []byte
+	Left bool
+}
+
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	node := MerkleNode{}
 
@@ -19,9 +30,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 		hash := sha256.Sum256(data)
 		node.Data = hash[:]
 	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash := sha256.Sum256(prevHashes)
-		node.Data = hash[:]
+		node.Data = hashPair(left.Data, right.Data)
 	}
 
 	node.Left = left
@@ -30,30 +39,90 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &node
 }
 
-func NewMerkeTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
+// NewMerkleTree builds tree level by level, when level has odd number of nodes
+// the last one is paired with itself
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var nodes []*MerkleNode
 
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+	for _, data := range data {
+		nodes = append(nodes, NewMerkleNode(nil, nil, data))
 	}
 
-	for _, data := range data {
-		node := NewMerkleNode(nil, nil, data)
-		nodes = append(nodes, *node)
+	if len(nodes) == 0 {
+		nodes = append(nodes, NewMerkleNode(nil, nil, []byte{}))
 	}
 
-	for i := 0; i < len(data)/2; i++ {
-		var level []MerkleNode
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		var level []*MerkleNode
 
-		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[i], &nodes[j+1], nil)
-			level = append(level, *node)
+		for i := 0; i < len(nodes); i += 2 {
+			level = append(level, NewMerkleNode(nodes[i], nodes[i+1], nil))
 		}
 
 		nodes = level
 	}
 
-	tree := MerkleTree{&nodes[0]}
+	tree := MerkleTree{nodes[0]}
 
 	return &tree
 }
+
+// Proof returns sibling hashes needed to rebuild the root from data,
+// ordered from the leaf level up
+func (t *MerkleTree) Proof(data []byte) ([]MerkleProofStep, error) {
+	leaf := sha256.Sum256(data)
+
+	proof, found := findPath(t.RootNode, leaf[:])
+
+	if !found {
+		return nil, errors.New("Data is not found in merkle tree")
+	}
+
+	return proof, nil
+}
+
+// VerifyProof checks that data is included in the tree with given root
+func VerifyProof(root, data []byte, proof []MerkleProofStep) bool {
+	leaf := sha256.Sum256(data)
+	hash := leaf[:]
+
+	for _, step := range proof {
+		if step.Left {
+			hash = hashPair(step.Hash, hash)
+		} else {
+			hash = hashPair(hash, step.Hash)
+		}
+	}
+
+	return bytes.Compare(hash, root) == 0
+}
+
+func findPath(node *MerkleNode, leaf []byte) ([]MerkleProofStep, bool) {
+	if node.Left == nil && node.Right == nil {
+		return nil, bytes.Compare(node.Data, leaf) == 0
+	}
+
+	if path, found := findPath(node.Left, leaf); found {
+		return append(path, MerkleProofStep{node.Right.Data, false}), true
+	}
+
+	if path, found := findPath(node.Right, leaf); found {
+		return append(path, MerkleProofStep{node.Left.Data, true}), true
+	}
+
+	return nil, false
+}
+
+func hashPair(left, right []byte) []byte {
+	data := make([]byte, 0, len(left)+len(right))
+	data = append(data, left...)
+	data = append(data, right...)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
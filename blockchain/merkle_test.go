@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func leaves(n int) [][]byte {
+	var data [][]byte
+
+	for i := 0; i < n; i++ {
+		data = append(data, []byte(fmt.Sprintf("tx %d", i)))
+	}
+
+	return data
+}
+
+func leafHash(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func TestMerkleRoot(t *testing.T) {
+	data := leaves(3)
+	a, b, c := leafHash(data[0]), leafHash(data[1]), leafHash(data[2])
+
+	// the last node of odd level is paired with itself
+	want := hashPair(hashPair(a, b), hashPair(c, c))
+
+	if got := NewMerkleTree(data).RootNode.Data; bytes.Compare(got, want) != 0 {
+		t.Errorf("root of 3 leaves is %x, want %x", got, want)
+	}
+
+	if got := NewMerkleTree(data[:1]).RootNode.Data; bytes.Compare(got, a) != 0 {
+		t.Errorf("root of single leaf is %x, want leaf hash %x", got, a)
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := leaves(n)
+		tree := NewMerkleTree(data)
+		root := tree.RootNode.Data
+
+		for _, leaf := range data {
+			proof, err := tree.Proof(leaf)
+
+			if err != nil {
+				t.Fatalf("%d leaves: %s", n, err)
+			}
+
+			if !VerifyProof(root, leaf, proof) {
+				t.Errorf("%d leaves: proof of %q is not valid", n, leaf)
+			}
+
+			if VerifyProof(root, []byte("other tx"), proof) {
+				t.Errorf("%d leaves: proof of %q is valid for other data", n, leaf)
+			}
+		}
+
+		if _, err := tree.Proof([]byte("other tx")); err == nil {
+			t.Errorf("%d leaves: proof of data outside the tree is built", n)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestMultisigScript(t *testing.T) {
+	var pubKeys [][]byte
+
+	for i := 0; i < 3; i++ {
+		pubKeys = append(pubKeys, wallet.MakeWallet().PublicKey)
+	}
+
+	script, err := MultisigScript(2, pubKeys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, keys, ok := ParseMultisigScript(script)
+
+	if !ok || m != 2 || len(keys) != len(pubKeys) {
+		t.Fatalf("multisig script is parsed as %d of %d keys, ok %t", m, len(keys), ok)
+	}
+
+	for i := range keys {
+		if bytes.Compare(keys[i], pubKeys[i]) != 0 {
+			t.Errorf("key %d is parsed as %x, want %x", i, keys[i], pubKeys[i])
+		}
+	}
+
+	if _, _, ok := ParseMultisigScript(P2PKHScript(wallet.PublicHash(pubKeys[0]))); ok {
+		t.Error("P2PKH script is parsed as multisig")
+	}
+
+	bounds := []struct {
+		m, n int
+	}{
+		{0, 3},
+		{4, 3},
+		{1, 0},
+		{1, MaxMultisigKeys + 1},
+	}
+
+	for _, b := range bounds {
+		keys := make([][]byte, b.n)
+
+		for i := range keys {
+			keys[i] = pubKeys[0]
+		}
+
+		if _, err := MultisigScript(b.m, keys); err == nil {
+			t.Errorf("%d of %d multisig script is built", b.m, b.n)
+		}
+	}
+}
+
+// TestMultisigSpend moves funds of 2-of-3 script address with signatures of two keys
+func TestMultisigSpend(t *testing.T) {
+	useRegTest(t)
+
+	owner := wallet.MakeWallet()
+	keys := []*wallet.Wallet{wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()}
+	chain := newTestChain(t, "multisig", owner, 100)
+	miner := walletAddress(wallet.MakeWallet())
+
+	redeemScript, err := MultisigScript(2, [][]byte{keys[0].PublicKey, keys[1].PublicKey, keys[2].PublicKey})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	multisigAddress := fmt.Sprintf("%s", wallet.ScriptAddress(redeemScript))
+	UTXOSet := UTXOSet{Blockchain: chain}
+
+	fund, err := NewTransaction(owner, multisigAddress, 50, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, fund)
+
+	if got := balance(chain, multisigAddress); got != 50 {
+		t.Fatalf("multisig address balance is %d, want 50", got)
+	}
+
+	recipient := walletAddress(wallet.MakeWallet())
+	spend, err := NewMultisigTransaction(multisigAddress, recipient, 40, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chain.SignMultisigTransaction(spend, owner, redeemScript); err == nil {
+		t.Error("transaction is signed by key outside of multisig")
+	}
+
+	complete, err := chain.SignMultisigTransaction(spend, keys[2], redeemScript)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if complete || chain.VerifyTransaction(spend) {
+		t.Fatal("transaction with a single signature is complete")
+	}
+
+	complete, err = chain.SignMultisigTransaction(spend, keys[0], redeemScript)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !complete {
+		t.Fatal("transaction with two signatures is not complete")
+	}
+
+	mineTx(t, chain, miner, spend)
+
+	if got := balance(chain, recipient); got != 40 {
+		t.Errorf("recipient balance is %d, want 40", got)
+	}
+
+	if got := balance(chain, multisigAddress); got != 9 {
+		t.Errorf("multisig address balance is %d, want 9", got)
+	}
+}
@@ -0,0 +1,67 @@
+package blockchain
+
+import "github.com/Dimashey/blockchain/chaincfg"
+
+// MaxMoney returns the most tokens an output or all outputs of a transaction may hold
+func MaxMoney() int {
+	return chaincfg.Active.MaxMoney
+}
+
+// addMoney adds value to total, which is within MaxMoney, unless value
+// is negative or the sum gets above MaxMoney
+func addMoney(total, value int) (int, bool) {
+	if value < 0 || value > MaxMoney() || total > MaxMoney()-value {
+		return total, false
+	}
+
+	return total + value, true
+}
+
+// Subsidy returns amount of new tokens miner of block at height receives
+func Subsidy(height int) int {
+	params := chaincfg.Active
+	halvings := uint(height / params.HalvingInterval)
+	subsidy := params.InitialSubsidy >> halvings
+
+	if subsidy < params.TerminalSubsidy {
+		subsidy = params.TerminalSubsidy
+	}
+
+	return subsidy
+}
+
+// ScheduledSupply returns number of tokens issued by blocks after genesis up to height.
+// Subsidy of the genesis block is burnt, premined tokens are counted by Chain.GenesisSupply
+func ScheduledSupply(height int) int {
+	supply := 0
+	interval := chaincfg.Active.HalvingInterval
+
+	for eraStart := 0; eraStart <= height; eraStart += interval {
+		blocks := interval
+
+		if eraStart+blocks > height {
+			blocks = height - eraStart + 1
+		}
+
+		supply += Subsidy(eraStart) * blocks
+	}
+
+	return supply - Subsidy(0)
+}
+
+// MaxSupply returns total number of tokens which will ever be issued by blocks after genesis,
+// or -1 when terminal subsidy keeps emission going forever
+func MaxSupply() int {
+	if chaincfg.Active.TerminalSubsidy > 0 {
+		return -1
+	}
+
+	supply := 0
+	interval := chaincfg.Active.HalvingInterval
+
+	for eraStart := 0; Subsidy(eraStart) > 0; eraStart += interval {
+		supply += Subsidy(eraStart) * interval
+	}
+
+	return supply - Subsidy(0)
+}
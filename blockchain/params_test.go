@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestSubsidy(t *testing.T) {
+	useRegTest(t)
+
+	subsidies := []struct {
+		height int
+		want   int
+	}{
+		{0, 20},
+		{149, 20},
+		{150, 10},
+		{300, 5},
+		{450, 2},
+		{600, 1},
+		{750, 0},
+		{100000, 0},
+	}
+
+	for _, s := range subsidies {
+		if got := Subsidy(s.height); got != s.want {
+			t.Errorf("subsidy at height %d is %d, want %d", s.height, got, s.want)
+		}
+	}
+
+	chaincfg.Active.TerminalSubsidy = 3
+
+	if got := Subsidy(600); got != 3 {
+		t.Errorf("subsidy below terminal one is %d, want 3", got)
+	}
+}
+
+func TestSupply(t *testing.T) {
+	useRegTest(t)
+
+	// genesis subsidy is burnt, so supply starts at height 1
+	issued := 0
+
+	for height := 0; height <= 1000; height++ {
+		if height > 0 {
+			issued += Subsidy(height)
+		}
+
+		if got := ScheduledSupply(height); got != issued {
+			t.Fatalf("supply at height %d is %d, want %d", height, got, issued)
+		}
+	}
+
+	if got := MaxSupply(); got != issued {
+		t.Errorf("max supply is %d, want %d", got, issued)
+	}
+
+	chaincfg.Active.TerminalSubsidy = 1
+
+	if got := MaxSupply(); got != -1 {
+		t.Errorf("max supply with terminal subsidy is %d, want -1", got)
+	}
+}
+
+func TestGenesisSupply(t *testing.T) {
+	useRegTest(t)
+
+	premined := newTestChain(t, "premined", wallet.MakeWallet(), 100)
+
+	if got := premined.GenesisSupply(); got != 100 {
+		t.Errorf("premined supply is %d, want 100", got)
+	}
+
+	burnt := InitBlockChain("burnt", ConsensusConfig{Engine: PoWEngine}, nil)
+	defer burnt.Database.Close()
+
+	if got := burnt.GenesisSupply(); got != 0 {
+		t.Errorf("supply of genesis without premine is %d, want 0", got)
+	}
+}
@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/Dimashey/blockchain/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+// ProofOfAuthority lets configured signers seal blocks in turns:
+// block at height h is sealed by Signers[h % len(Signers)].
+// Seal of a block is public key of the signer followed by its signature of the header.
+type ProofOfAuthority struct {
+	// Signers are public key hashes of keys allowed to seal blocks
+	Signers [][]byte
+	// Signer is the key of this node, only required to seal blocks
+	Signer *wallet.Wallet
+}
+
+var errNotInTurn = errors.New("signer is not in turn to seal the block")
+
+func (e *ProofOfAuthority) inTurn(height int) []byte {
+	return e.Signers[height%len(e.Signers)]
+}
+
+// config returns consensus config the genesis block of the engine chain commits to
+func (e *ProofOfAuthority) config() ConsensusConfig {
+	return ConsensusConfig{Engine: PoAEngine, Signers: e.Signers}
+}
+
+// Seal signs the block header. Seal of the genesis block is the engine config instead,
+// as no signer can sign the block committing to signers themselves
+func (e *ProofOfAuthority) Seal(ctx context.Context, block *Block) error {
+	block.Seal = nil
+
+	if block.Height == 0 {
+		block.Seal = e.config().genesisSeal()
+		block.Hash = block.BlockHeader.Hash()
+		return nil
+	}
+
+	if e.Signer == nil {
+		return errors.New("proof-of-authority signer key is not set")
+	}
+
+	if bytes.Compare(wallet.PublicHash(e.Signer.PublicKey), e.inTurn(block.Height)) != 0 {
+		return errNotInTurn
+	}
+
+	signature, err := signHash(e.Signer, block.SealHash())
+
+	if err != nil {
+		return err
+	}
+
+	var enc encoder
+
+	enc.writeBytes(e.Signer.PublicKey)
+	enc.writeBytes(signature)
+
+	block.Seal = enc.buf.Bytes()
+	block.Hash = block.BlockHeader.Hash()
+
+	return nil
+}
+
+// VerifySeal checks signature of the signer in turn, genesis block has to commit
+// to the signers of the engine
+func (e *ProofOfAuthority) VerifySeal(block *Block) error {
+	if block.Height == 0 {
+		if bytes.Compare(block.Seal, e.config().genesisSeal()) != 0 {
+			return rejectf(RejectBadSeal, "genesis block does not commit to the signers of the chain")
+		}
+
+		return nil
+	}
+
+	d := newDecoder(block.Seal)
+	pubKey := d.readBytes()
+	signature := d.readBytes()
+
+	if err := d.finish(); err != nil {
+		return rejectf(RejectBadSeal, "block seal is malformed")
+	}
+
+	if bytes.Compare(wallet.PublicHash(pubKey), e.inTurn(block.Height)) != 0 {
+		return rejectf(RejectBadSeal, "block %d is sealed by signer out of turn", block.Height)
+	}
+
+	if !checkSignature(signature, pubKey, block.SealHash()) {
+		return rejectf(RejectBadSeal, "block seal signature is invalid")
+	}
+
+	return nil
+}
+
+// NextBits keeps the easiest target, so every block adds the same work
+// and the longest chain wins
+func (e *ProofOfAuthority) NextBits(txn *badger.Txn, parent *Block) (uint32, error) {
+	return BigToCompact(powLimit()), nil
+}
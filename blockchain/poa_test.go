@@ -0,0 +1,135 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// TestProofOfAuthorityGenesis checks that signers are taken from the genesis block
+// rather than from the config of the node continuing the chain
+func TestProofOfAuthorityGenesis(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	config := ConsensusConfig{Engine: PoAEngine, Signers: [][]byte{wallet.PublicHash(alice.PublicKey), wallet.PublicHash(bob.PublicKey)}}
+	genesisFile := &chaincfg.Genesis{Alloc: []chaincfg.GenesisAlloc{{Address: walletAddress(alice), Amount: 100}}}
+
+	genesis, err := GenesisBlock(genesisFile, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err := GenesisConsensus(genesis)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(committed.Serialize(), config.Serialize()) != 0 {
+		t.Errorf("genesis commits to %+v, want %+v", committed, config)
+	}
+
+	powGenesis, err := GenesisBlock(genesisFile, ConsensusConfig{Engine: PoWEngine})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(genesis.Hash, powGenesis.Hash) == 0 {
+		t.Error("genesis does not depend on consensus config")
+	}
+
+	engine, _ := NewEngine(config)
+
+	if err := engine.VerifySeal(genesis); err != nil {
+		t.Errorf("genesis of the signers is rejected: %s", err)
+	}
+
+	others, _ := NewEngine(ConsensusConfig{Engine: PoAEngine, Signers: config.Signers[:1]})
+
+	if err := others.VerifySeal(genesis); !rejected(err, RejectBadSeal) {
+		t.Errorf("genesis of other signers is verified with error %v, want %s", err, RejectBadSeal)
+	}
+
+	if err := (&ProofOfWorkEngine{}).VerifySeal(genesis); !rejected(err, RejectBadSeal) {
+		t.Errorf("proof-of-authority genesis is verified by proof-of-work with error %v, want %s", err, RejectBadSeal)
+	}
+
+	chain := InitBlockChain("poa", config, genesisFile)
+	chain.Database.Close()
+
+	chain = ContinueBlockChain("poa")
+	defer chain.Database.Close()
+
+	poa, ok := chain.Engine.(*ProofOfAuthority)
+
+	if !ok {
+		t.Fatalf("chain is continued with %T", chain.Engine)
+	}
+
+	if got := poa.config(); bytes.Compare(got.Serialize(), config.Serialize()) != 0 {
+		t.Errorf("chain is continued with signers %x, want %x", got.Signers, config.Signers)
+	}
+}
+
+// TestProofOfAuthoritySeal lets signers seal blocks in turns
+func TestProofOfAuthoritySeal(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	config := ConsensusConfig{Engine: PoAEngine, Signers: [][]byte{wallet.PublicHash(alice.PublicKey), wallet.PublicHash(bob.PublicKey)}}
+
+	chain := InitBlockChain("poa", config, nil)
+	defer chain.Database.Close()
+
+	poa := chain.Engine.(*ProofOfAuthority)
+	address := walletAddress(alice)
+
+	// block 1 is bob's turn
+	poa.Signer = alice
+
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{CoinbaseTx(address, "", 1, 0)}); err != errNotInTurn {
+		t.Fatalf("block is sealed out of turn with error %v", err)
+	}
+
+	poa.Signer = bob
+
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{CoinbaseTx(address, "", 1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tip, err := chain.GetBlock(chain.Tip())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// alice seals block 2, which is her turn, with engine believing she is in turn for block 3 too
+	forger := &ProofOfAuthority{Signers: [][]byte{config.Signers[0], config.Signers[0]}, Signer: alice}
+
+	for _, height := range []int{2, 3} {
+		parent := &tip
+		block := NewBlock([]*Transaction{CoinbaseTx(address, "", height, 0)}, parent.Hash, height, parent.Bits, parent.Timestamp+1)
+
+		if err := forger.Seal(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+
+		err := chain.AddBlock(block)
+
+		if height == 2 && err != nil {
+			t.Fatalf("block sealed in turn is rejected: %s", err)
+		}
+
+		if height == 3 && !rejected(err, RejectBadSeal) {
+			t.Errorf("block sealed out of turn is added with error %v, want %s", err, RejectBadSeal)
+		}
+
+		tip = *block
+	}
+}
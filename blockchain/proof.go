@@ -1,76 +1,207 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
-	"fmt"
+	"errors"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Dimashey/blockchain/internal/util"
 )
 
-const Difficulty = 18
+// MaxNonce is the last nonce miner tries before changing the coinbase extra nonce
+const MaxNonce = math.MaxUint32
+
+// MinerThreads is number of goroutines searching for nonce
+var MinerThreads = runtime.NumCPU()
+
+var errNonceExhausted = errors.New("nonce space is exhausted")
 
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
+	// Hashes is number of hashes tried by Mine
+	Hashes uint64
 }
 
+// NewProof uses target stored in the block itself,
+// so blocks mined before a retarget stay valid after it
 func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
+	target := CompactToBig(b.Bits)
 
-	// multiply target value by two
-	target.Lsh(target, uint(256-Difficulty))
-
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{Block: b, Target: target}
 
 	return pow
 }
 
-func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join([][]byte{
-		pow.Block.PrevHash,
-		pow.Block.HashTransactions(),
-		util.ToHex(int64(nonce)),
-		util.ToHex(int64(Difficulty)),
-	}, []byte{})
+// InitData returns encoded block header with given nonce
+func (pow *ProofOfWork) InitData(nonce int64) []byte {
+	header := pow.Block.BlockHeader
+	header.Nonce = nonce
 
-	return data
+	return header.Serialize()
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
+// Mine looks for nonce giving block hash below the target. Once the whole nonce
+// space is searched, extra nonce in coinbase data is changed, which gives
+// a new merkle root and so a fresh nonce space to search over
+func (pow *ProofOfWork) Mine(ctx context.Context) error {
+	if len(pow.Block.Transactions) == 0 || !pow.Block.Transactions[0].IsCoinbase() {
+		return errors.New("Block without coinbase can not be mined")
+	}
 
-	nonce := 0
+	coinbase := pow.Block.Transactions[0]
+	data := coinbase.Inputs[0].Script
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+	for extraNonce := int64(0); ; extraNonce++ {
+		if extraNonce > 0 {
+			coinbase.Inputs[0].Script = append(append([]byte{}, data...), util.ToHex(extraNonce)...)
+			coinbase.ID = coinbase.Hash()
+			pow.Block.MerkleRoot = pow.Block.HashTransactions()
+			pow.Block.WitnessRoot = pow.Block.HashWitnesses()
+		}
 
-		fmt.Printf("\r%x", hash)
-		intHash.SetBytes(hash[:])
+		nonce, hash, err := pow.Run(ctx)
 
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+		if err == errNonceExhausted {
+			continue
 		}
+
+		if err != nil {
+			return err
+		}
+
+		pow.Block.Nonce = nonce
+		pow.Block.Hash = hash
+
+		return nil
+	}
+}
+
+// Run splits nonce space between MinerThreads goroutines, each of them checks
+// every MinerThreads-th nonce. It stops when ctx is cancelled, for example
+// when a competing block arrives and the block being mined becomes stale
+func (pow *ProofOfWork) Run(ctx context.Context) (int64, []byte, error) {
+	type result struct {
+		nonce int64
+		hash  []byte
 	}
 
-	fmt.Println()
+	workers := MinerThreads
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan result, workers)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(first int64) {
+			defer wg.Done()
+
+			var intHash big.Int
+			var count uint64
+
+			defer func() { atomic.AddUint64(&pow.Hashes, count) }()
+
+			header := pow.Block.BlockHeader
+
+			for nonce := first; nonce <= MaxNonce; nonce += int64(workers) {
+				if count%4096 == 0 && searchCtx.Err() != nil {
+					return
+				}
+
+				header.Nonce = nonce
+				hash := sha256.Sum256(header.Serialize())
+				count++
+
+				intHash.SetBytes(hash[:])
 
-	return nonce, hash[:]
+				if intHash.Cmp(pow.Target) == -1 {
+					found <- result{nonce, hash[:]}
+					return
+				}
+			}
+		}(int64(w))
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var res *result
+
+	select {
+	case r := <-found:
+		res = &r
+	case <-done:
+	}
+
+	cancel()
+	<-done
+
+	if res == nil {
+		select {
+		case r := <-found:
+			res = &r
+		default:
+		}
+	}
+
+	if res != nil {
+		return res.nonce, res.hash, nil
+	}
+
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+
+	return 0, nil, errNonceExhausted
+}
+
+// sealGenesis looks for the lowest nonce satisfying proof-of-work on a single goroutine,
+// so every node building the genesis block gets the same header. Genesis block is sealed
+// with proof-of-work whatever engine the chain uses, engines don't verify its seal
+func sealGenesis(block *Block) error {
+	pow := NewProof(block)
+
+	for nonce := int64(0); nonce <= MaxNonce; nonce++ {
+		block.Nonce = nonce
+
+		if pow.Validate() {
+			block.Hash = block.BlockHeader.Hash()
+			return nil
+		}
+	}
+
+	return errNonceExhausted
+}
+
+// Hash returns block hash for given nonce
+func (pow *ProofOfWork) Hash(nonce int64) []byte {
+	hash := sha256.Sum256(pow.InitData(nonce))
+
+	return hash[:]
 }
 
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
 
-	data := pow.InitData(pow.Block.Nonce)
-
-	hash := sha256.Sum256(data)
-	intHash.SetBytes(hash[:])
+	hash := pow.Hash(pow.Block.Nonce)
+	intHash.SetBytes(hash)
 
 	return intHash.Cmp(pow.Target) == -1
 }
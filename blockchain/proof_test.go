@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func minerBlock(bits uint32) *Block {
+	coinbase := CoinbaseTx(walletAddress(wallet.MakeWallet()), "", 1, 0)
+
+	return NewBlock([]*Transaction{coinbase}, []byte{0x01}, 1, bits, 1704067200)
+}
+
+func TestSeal(t *testing.T) {
+	useRegTest(t)
+
+	previous := MinerThreads
+	MinerThreads = 4
+	t.Cleanup(func() { MinerThreads = previous })
+
+	// one of 256 hashes satisfies the target
+	block := minerBlock(0x2000ffff)
+	engine := &ProofOfWorkEngine{}
+
+	if err := engine.Seal(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.VerifySeal(block); err != nil {
+		t.Errorf("sealed block is not valid: %s", err)
+	}
+
+	if engine.HashRate() <= 0 {
+		t.Errorf("hash rate is %f after sealing", engine.HashRate())
+	}
+}
+
+func TestMineCancelled(t *testing.T) {
+	// no hash is below target 1 in practice
+	pow := NewProof(minerBlock(0x01010000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := pow.Mine(ctx); err != context.Canceled {
+		t.Errorf("mining with cancelled context returns %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,554 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// Scripts lock outputs and unlock them in inputs. Script is a sequence of opcodes
+// run on a stack of byte strings: unlocking script of input is run first,
+// then locking script of the spent output is run on the stack it left.
+// Output is unlocked when the stack top is true afterwards, see interpreter.go
+//
+// Opcodes up to OP_PUSHDATA2 push data:
+//
+//	0x00          OP_0, pushes empty string
+//	0x01...0x4b   pushes the next n bytes
+//	0x4c          OP_PUSHDATA1, next byte is length of pushed data
+//	0x4d          OP_PUSHDATA2, next 2 bytes are little-endian length of pushed data
+//	0x51...0x60   OP_1...OP_16, push number 1...16
+type Opcode byte
+
+const (
+	OP_0         Opcode = 0x00
+	OP_PUSHDATA1 Opcode = 0x4c
+	OP_PUSHDATA2 Opcode = 0x4d
+	OP_1NEGATE   Opcode = 0x4f
+	OP_RESERVED  Opcode = 0x50
+	OP_1         Opcode = 0x51
+	OP_16        Opcode = 0x60
+
+	OP_NOP    Opcode = 0x61
+	OP_IF     Opcode = 0x63
+	OP_NOTIF  Opcode = 0x64
+	OP_ELSE   Opcode = 0x67
+	OP_ENDIF  Opcode = 0x68
+	OP_VERIFY Opcode = 0x69
+	OP_RETURN Opcode = 0x6a
+
+	OP_DROP Opcode = 0x75
+	OP_DUP  Opcode = 0x76
+	OP_SWAP Opcode = 0x7c
+	OP_SIZE Opcode = 0x82
+
+	OP_EQUAL       Opcode = 0x87
+	OP_EQUALVERIFY Opcode = 0x88
+
+	OP_SHA256              Opcode = 0xa8
+	OP_HASH160             Opcode = 0xa9
+	OP_CHECKSIG            Opcode = 0xac
+	OP_CHECKSIGVERIFY      Opcode = 0xad
+	OP_CHECKMULTISIG       Opcode = 0xae
+	OP_CHECKMULTISIGVERIFY Opcode = 0xaf
+
+	OP_CHECKLOCKTIMEVERIFY Opcode = 0xb1
+	OP_CHECKSEQUENCEVERIFY Opcode = 0xb2
+)
+
+var opcodeNames = map[Opcode]string{
+	OP_0:              "OP_0",
+	OP_PUSHDATA1:      "OP_PUSHDATA1",
+	OP_PUSHDATA2:      "OP_PUSHDATA2",
+	OP_1NEGATE:        "OP_1NEGATE",
+	OP_RESERVED:       "OP_RESERVED",
+	OP_NOP:            "OP_NOP",
+	OP_IF:             "OP_IF",
+	OP_NOTIF:          "OP_NOTIF",
+	OP_ELSE:           "OP_ELSE",
+	OP_ENDIF:          "OP_ENDIF",
+	OP_VERIFY:         "OP_VERIFY",
+	OP_RETURN:         "OP_RETURN",
+	OP_DROP:           "OP_DROP",
+	OP_DUP:            "OP_DUP",
+	OP_SWAP:           "OP_SWAP",
+	OP_SIZE:           "OP_SIZE",
+	OP_EQUAL:          "OP_EQUAL",
+	OP_EQUALVERIFY:    "OP_EQUALVERIFY",
+	OP_SHA256:         "OP_SHA256",
+	OP_HASH160:        "OP_HASH160",
+	OP_CHECKSIG:       "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY",
+
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+func (op Opcode) String() string {
+	if op >= OP_1 && op <= OP_16 {
+		return fmt.Sprintf("OP_%d", op-OP_1+1)
+	}
+
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OP_UNKNOWN_%02x", byte(op))
+}
+
+var errMalformedScript = errors.New("script is malformed")
+
+// ScriptOp is a parsed script instruction, Data is set for push opcodes
+type ScriptOp struct {
+	Opcode Opcode
+	Data   []byte
+}
+
+// isPush reports whether instruction only pushes data or a small number
+func (op ScriptOp) isPush() bool {
+	return op.Opcode <= OP_16 && op.Opcode != OP_RESERVED
+}
+
+// hasData reports whether instruction pushes data following the opcode
+func (op ScriptOp) hasData() bool {
+	return op.Opcode > OP_0 && op.Opcode <= OP_PUSHDATA2
+}
+
+// ParseScript splits script into instructions
+func ParseScript(script []byte) ([]ScriptOp, error) {
+	var ops []ScriptOp
+
+	for i := 0; i < len(script); {
+		op := Opcode(script[i])
+		i++
+
+		n := 0
+
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errMalformedScript
+			}
+
+			n = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errMalformedScript
+			}
+
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+
+		if i+n > len(script) {
+			return nil, errMalformedScript
+		}
+
+		ops = append(ops, ScriptOp{Opcode: op, Data: script[i : i+n]})
+		i += n
+	}
+
+	return ops, nil
+}
+
+// ScriptBuilder assembles script with the shortest push of every data
+type ScriptBuilder struct {
+	buf bytes.Buffer
+}
+
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+func (b *ScriptBuilder) AddOp(op Opcode) *ScriptBuilder {
+	b.buf.WriteByte(byte(op))
+
+	return b
+}
+
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch n := len(data); {
+	case n == 0:
+		b.buf.WriteByte(byte(OP_0))
+	case n < int(OP_PUSHDATA1):
+		b.buf.WriteByte(byte(n))
+	case n <= 0xff:
+		b.buf.WriteByte(byte(OP_PUSHDATA1))
+		b.buf.WriteByte(byte(n))
+	default:
+		var length [2]byte
+		binary.LittleEndian.PutUint16(length[:], uint16(n))
+		b.buf.WriteByte(byte(OP_PUSHDATA2))
+		b.buf.Write(length[:])
+	}
+
+	b.buf.Write(data)
+
+	return b
+}
+
+// AddInt pushes number, small numbers use OP_1NEGATE and OP_0...OP_16
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	switch {
+	case n == 0:
+		return b.AddOp(OP_0)
+	case n == -1:
+		return b.AddOp(OP_1NEGATE)
+	case n >= 1 && n <= 16:
+		return b.AddOp(OP_1 + Opcode(n-1))
+	}
+
+	return b.AddData(encodeScriptNum(n))
+}
+
+func (b *ScriptBuilder) Script() []byte {
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+// DisasmScript returns human readable form of script
+func DisasmScript(script []byte) string {
+	ops, err := ParseScript(script)
+
+	if err != nil {
+		return fmt.Sprintf("[malformed %x]", script)
+	}
+
+	var parts []string
+
+	for _, op := range ops {
+		if op.hasData() {
+			parts = append(parts, fmt.Sprintf("%x", op.Data))
+		} else {
+			parts = append(parts, op.Opcode.String())
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Numbers in scripts are little-endian with the sign in the highest bit of the last byte,
+// zero is an empty string
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0)
+
+		if negative {
+			extra = 0x80
+		}
+
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+const (
+	// maxScriptNumLen limits length of numbers read from the stack
+	maxScriptNumLen = 4
+	// lockTimeNumLen is length of lock times, which don't fit in 4 bytes of signed number
+	lockTimeNumLen = 5
+)
+
+func decodeScriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, fmt.Errorf("script number is longer than %d bytes", maxLen)
+	}
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+
+	for i, b := range data {
+		n |= int64(b) << uint(8*i)
+	}
+
+	if data[len(data)-1]&0x80 != 0 {
+		n &^= int64(0x80) << uint(8*(len(data)-1))
+
+		return -n, nil
+	}
+
+	return n, nil
+}
+
+// smallInt returns number pushed by OP_0 and OP_1...OP_16
+func (op ScriptOp) smallInt() (int, bool) {
+	switch {
+	case op.Opcode == OP_0:
+		return 0, true
+	case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+		return int(op.Opcode-OP_1) + 1, true
+	}
+
+	return 0, false
+}
+
+// number returns number pushed by instruction, either small or encoded in at most maxLen bytes
+func (op ScriptOp) number(maxLen int) (int64, bool) {
+	if small, ok := op.smallInt(); ok {
+		return int64(small), true
+	}
+
+	if !op.hasData() {
+		return 0, false
+	}
+
+	n, err := decodeScriptNum(op.Data, maxLen)
+
+	return n, err == nil
+}
+
+// LockScript returns script locking output to address,
+// which is either key or script address, see wallet.DecodeAddress
+func LockScript(address string) []byte {
+	version, hash := wallet.DecodeAddress(address)
+
+	if version == chaincfg.Active.ScriptHashVersion {
+		return P2SHScript(hash)
+	}
+
+	return P2PKHScript(hash)
+}
+
+// P2PKHScript locks output to owner of public key with hash pubKeyHash:
+//
+//	OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// it is unlocked by <signature> <pubKey>
+func P2PKHScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// P2PKHUnlockScript unlocks output locked with P2PKHScript
+func P2PKHUnlockScript(signature, pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(signature).AddData(pubKey).Script()
+}
+
+// ExtractPubKeyHash returns public key hash of P2PKH locking script or nil
+// when script is of another kind
+func ExtractPubKeyHash(script []byte) []byte {
+	ops, err := ParseScript(script)
+
+	if err != nil || len(ops) != 5 {
+		return nil
+	}
+
+	if ops[0].Opcode != OP_DUP || ops[1].Opcode != OP_HASH160 || !ops[2].hasData() ||
+		ops[3].Opcode != OP_EQUALVERIFY || ops[4].Opcode != OP_CHECKSIG {
+		return nil
+	}
+
+	return ops[2].Data
+}
+
+// P2SHScript locks output to script with hash scriptHash:
+//
+//	OP_HASH160 <scriptHash> OP_EQUAL
+//
+// it is unlocked by data unlocking the script followed by the script itself,
+// which is then run on the rest of the data, see VerifyScript
+func P2SHScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OP_HASH160).
+		AddData(scriptHash).
+		AddOp(OP_EQUAL).
+		Script()
+}
+
+// ExtractScriptHash returns script hash of P2SH locking script or nil
+// when script is of another kind
+func ExtractScriptHash(script []byte) []byte {
+	ops, err := ParseScript(script)
+
+	if err != nil || len(ops) != 3 {
+		return nil
+	}
+
+	if ops[0].Opcode != OP_HASH160 || !ops[1].hasData() || ops[2].Opcode != OP_EQUAL {
+		return nil
+	}
+
+	return ops[1].Data
+}
+
+// MaxMultisigKeys is the most keys multisig script can hold
+const MaxMultisigKeys = 16
+
+// MultisigScript requires m signatures of any of pubKeys:
+//
+//	OP_m <pubKey1> ... <pubKeyN> OP_n OP_CHECKMULTISIG
+//
+// it is unlocked by m signatures in the same order as their keys
+func MultisigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig takes 1 to %d keys, %d are given", MaxMultisigKeys, len(pubKeys))
+	}
+
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("multisig requires 1 to %d signatures, %d are asked", len(pubKeys), m)
+	}
+
+	b := NewScriptBuilder().AddInt(int64(m))
+
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+
+	script := b.AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script()
+
+	// script is pushed when P2SH output is spent
+	if len(script) > MaxScriptElementSize {
+		return nil, fmt.Errorf("multisig script takes %d bytes, at most %d can be pushed", len(script), MaxScriptElementSize)
+	}
+
+	return script, nil
+}
+
+// ParseMultisigScript returns number of required signatures and keys
+// of script built with MultisigScript, ok is false for other scripts
+func ParseMultisigScript(script []byte) (m int, pubKeys [][]byte, ok bool) {
+	ops, err := ParseScript(script)
+
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].Opcode != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+
+	m, okM := ops[0].smallInt()
+	n, okN := ops[len(ops)-2].smallInt()
+
+	if !okM || !okN || n != len(ops)-3 || m < 1 || m > n {
+		return 0, nil, false
+	}
+
+	for _, op := range ops[1 : len(ops)-2] {
+		if !op.hasData() {
+			return 0, nil, false
+		}
+
+		pubKeys = append(pubKeys, op.Data)
+	}
+
+	return m, pubKeys, true
+}
+
+// TimelockScript locks output to owner of public key with hash pubKeyHash
+// until lock given as transaction lock time with OP_CHECKLOCKTIMEVERIFY
+// or as input relative lock with OP_CHECKSEQUENCEVERIFY:
+//
+//	<lock> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// it is unlocked by <signature> <pubKey> just like P2PKHScript
+func TimelockScript(op Opcode, lock int64, pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddInt(lock).
+		AddOp(op).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// ParseTimelockScript returns lock opcode, lock and public key hash
+// of script built with TimelockScript, ok is false for other scripts
+func ParseTimelockScript(script []byte) (op Opcode, lock int64, pubKeyHash []byte, ok bool) {
+	ops, err := ParseScript(script)
+
+	if err != nil || len(ops) != 8 {
+		return 0, 0, nil, false
+	}
+
+	op = ops[1].Opcode
+
+	if op != OP_CHECKLOCKTIMEVERIFY && op != OP_CHECKSEQUENCEVERIFY {
+		return 0, 0, nil, false
+	}
+
+	if lock, ok = ops[0].number(lockTimeNumLen); !ok {
+		return 0, 0, nil, false
+	}
+
+	if ops[2].Opcode != OP_DROP || ops[3].Opcode != OP_DUP || ops[4].Opcode != OP_HASH160 ||
+		!ops[5].hasData() || ops[6].Opcode != OP_EQUALVERIFY || ops[7].Opcode != OP_CHECKSIG {
+		return 0, 0, nil, false
+	}
+
+	pubKeyHash = ops[5].Data
+
+	return op, lock, pubKeyHash, true
+}
+
+// MaxDataCarrierSize is the maximum size of data carried by DataScript output
+const MaxDataCarrierSize = 80
+
+// DataScript makes output carrying data, which is provably unspendable:
+//
+//	OP_RETURN <data>
+//
+// such outputs are never added to the UTXO set
+func DataScript(data []byte) ([]byte, error) {
+	if len(data) > MaxDataCarrierSize {
+		return nil, fmt.Errorf("data of %d bytes is larger than %d", len(data), MaxDataCarrierSize)
+	}
+
+	return NewScriptBuilder().AddOp(OP_RETURN).AddData(data).Script(), nil
+}
+
+// IsUnspendable reports whether no unlocking script can spend output with script
+func IsUnspendable(script []byte) bool {
+	return (len(script) > 0 && Opcode(script[0]) == OP_RETURN) || len(script) > MaxScriptSize
+}
+
+// ExtractData returns data of script built with DataScript, ok is false for other scripts
+func ExtractData(script []byte) (data []byte, ok bool) {
+	ops, err := ParseScript(script)
+
+	if err != nil || len(ops) != 2 || ops[0].Opcode != OP_RETURN || !ops[1].isPush() {
+		return nil, false
+	}
+
+	if len(ops[1].Data) > MaxDataCarrierSize {
+		return nil, false
+	}
+
+	return ops[1].Data, true
+}
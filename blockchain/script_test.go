@@ -0,0 +1,154 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestScriptNum(t *testing.T) {
+	numbers := []struct {
+		n       int64
+		encoded []byte
+	}{
+		{0, nil},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-256, []byte{0x00, 0x81}},
+		{500000000, []byte{0x00, 0x65, 0xcd, 0x1d}},
+	}
+
+	for _, num := range numbers {
+		encoded := encodeScriptNum(num.n)
+
+		if bytes.Compare(encoded, num.encoded) != 0 {
+			t.Errorf("%d is encoded as %x, want %x", num.n, encoded, num.encoded)
+		}
+
+		if n, err := decodeScriptNum(encoded, maxScriptNumLen); err != nil || n != num.n {
+			t.Errorf("%x is decoded as %d with error %v, want %d", encoded, n, err, num.n)
+		}
+	}
+
+	if _, err := decodeScriptNum([]byte{0x00, 0x00, 0x00, 0x00, 0x01}, maxScriptNumLen); err == nil {
+		t.Errorf("number longer than %d bytes is decoded", maxScriptNumLen)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	long := bytes.Repeat([]byte{0xaa}, 300)
+	script := NewScriptBuilder().AddInt(2).AddData([]byte{0x01, 0x02}).AddData(long).AddOp(OP_EQUAL).Script()
+
+	ops, err := ParseScript(script)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 4 || ops[0].Opcode != OP_1+1 || bytes.Compare(ops[1].Data, []byte{0x01, 0x02}) != 0 ||
+		ops[2].Opcode != OP_PUSHDATA2 || bytes.Compare(ops[2].Data, long) != 0 || ops[3].Opcode != OP_EQUAL {
+		t.Errorf("script %x is parsed as %v", script, ops)
+	}
+
+	if got := DisasmScript(P2PKHScript([]byte{0xab, 0xcd})); got != "OP_DUP OP_HASH160 abcd OP_EQUALVERIFY OP_CHECKSIG" {
+		t.Errorf("P2PKH script is disassembled as %q", got)
+	}
+
+	malformed := [][]byte{
+		{0x02, 0x01},
+		{byte(OP_PUSHDATA1)},
+		{byte(OP_PUSHDATA1), 0x02, 0x01},
+		{byte(OP_PUSHDATA2), 0x01},
+	}
+
+	for _, script := range malformed {
+		if _, err := ParseScript(script); err != errMalformedScript {
+			t.Errorf("script %x is parsed with error %v, want %v", script, err, errMalformedScript)
+		}
+	}
+}
+
+// TestVerifyScript runs unlocking and locking scripts which don't check signatures
+func TestVerifyScript(t *testing.T) {
+	secret := []byte("secret")
+	secretHash := sha256.Sum256(secret)
+
+	hashLock := NewScriptBuilder().AddOp(OP_SHA256).AddData(secretHash[:]).AddOp(OP_EQUAL).Script()
+	branches := NewScriptBuilder().AddOp(OP_IF).AddInt(1).AddOp(OP_ELSE).AddInt(0).AddOp(OP_ENDIF).Script()
+
+	scripts := []struct {
+		name   string
+		unlock []byte
+		lock   []byte
+		valid  bool
+	}{
+		{"hash preimage", NewScriptBuilder().AddData(secret).Script(), hashLock, true},
+		{"wrong preimage", NewScriptBuilder().AddData([]byte("guess")).Script(), hashLock, false},
+		{"true branch", NewScriptBuilder().AddInt(1).Script(), branches, true},
+		{"false branch", NewScriptBuilder().AddInt(0).Script(), branches, false},
+		{"unclosed OP_IF", NewScriptBuilder().AddInt(1).Script(), NewScriptBuilder().AddOp(OP_IF).AddInt(1).Script(), false},
+		{"failed OP_VERIFY", nil, NewScriptBuilder().AddInt(0).AddOp(OP_VERIFY).AddInt(1).Script(), false},
+		{"OP_RETURN", nil, NewScriptBuilder().AddOp(OP_RETURN).Script(), false},
+		{"empty stack", nil, nil, false},
+		{"opcode in unlocking script", NewScriptBuilder().AddData(secret).AddOp(OP_NOP).Script(), hashLock, false},
+	}
+
+	for _, s := range scripts {
+		tx := &Transaction{Inputs: []TxInput{{[]byte{0x01}, 0, s.unlock, MaxSequence}}}
+		err := VerifyScript(tx, 0, TxOutput{Value: 1, Script: s.lock})
+
+		if s.valid && err != nil {
+			t.Errorf("%s is rejected: %s", s.name, err)
+		}
+
+		if !s.valid && err == nil {
+			t.Errorf("%s is verified", s.name)
+		}
+	}
+}
+
+// TestVerifyP2PKH spends pay-to-pubkey-hash output with signature of its key
+func TestVerifyP2PKH(t *testing.T) {
+	owner := wallet.MakeWallet()
+	prevOut := TxOutput{Value: 10, Script: P2PKHScript(wallet.PublicHash(owner.PublicKey))}
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{[]byte{0x01}, 0, nil, MaxSequence}},
+		Outputs: []TxOutput{{Value: 10, Script: P2PKHScript(wallet.PublicHash(wallet.MakeWallet().PublicKey))}},
+	}
+
+	sign := func(w *wallet.Wallet) {
+		signature, err := tx.signInput(w, 0, prevOut.Script, SigHashAll)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx.Inputs[0].Script = P2PKHUnlockScript(signature, w.PublicKey)
+	}
+
+	sign(owner)
+
+	if err := VerifyScript(tx, 0, prevOut); err != nil {
+		t.Fatalf("owner signature is rejected: %s", err)
+	}
+
+	tx.Outputs[0].Value = 9
+
+	if err := VerifyScript(tx, 0, prevOut); err == nil {
+		t.Error("signature is verified after transaction is changed")
+	}
+
+	sign(wallet.MakeWallet())
+
+	if err := VerifyScript(tx, 0, prevOut); err == nil {
+		t.Error("signature of other key is verified")
+	}
+}
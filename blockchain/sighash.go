@@ -0,0 +1,163 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// SigHashType selects parts of transaction signature commits to, it is appended
+// to every signature in unlocking script. Base types are:
+//
+//	SigHashAll     all inputs and outputs
+//	SigHashNone    all inputs, but no outputs, so anyone can choose where coins go
+//	SigHashSingle  all inputs and only the output with the same index as the input
+//
+// combined with SigHashAnyoneCanPay only the signed input is committed to,
+// so others can add their inputs, e.g. to fund transaction together
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashBaseMask = 0x1f
+)
+
+func (t SigHashType) base() SigHashType {
+	return t & sigHashBaseMask
+}
+
+func (t SigHashType) isValid() bool {
+	base := t &^ SigHashAnyoneCanPay
+
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+
+	switch t.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN_%02x", byte(t))
+	}
+
+	if t&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// ParseSigHashType parses names like ALL, SINGLE|ANYONECANPAY
+func ParseSigHashType(name string) (SigHashType, error) {
+	var t SigHashType
+
+	bases := map[string]SigHashType{"ALL": SigHashAll, "NONE": SigHashNone, "SINGLE": SigHashSingle}
+
+	for _, part := range strings.Split(strings.ToUpper(name), "|") {
+		base, isBase := bases[part]
+
+		switch {
+		case isBase && t.base() == 0:
+			t |= base
+		case isBase:
+			// bases would be combined into another one, ALL|NONE is SINGLE
+			return 0, fmt.Errorf("signature hash type %s has more than one base type", name)
+		case part == "ANYONECANPAY":
+			t |= SigHashAnyoneCanPay
+		default:
+			return 0, fmt.Errorf("unknown signature hash type %s", part)
+		}
+	}
+
+	if !t.isValid() {
+		return 0, fmt.Errorf("signature hash type %s is not valid", name)
+	}
+
+	return t, nil
+}
+
+// SignatureHash returns hash signed by unlocking script of input at index,
+// which is hash of transaction without unlocking scripts where the input
+// holds locking script of the output it spends, or the script itself
+// when the output is locked with script hash. Inputs and outputs
+// left out by hashType are removed, and hashType itself is committed to.
+// With SigHashNone and SigHashSingle sequences of other inputs are not committed to either.
+func (tx *Transaction) SignatureHash(index int, lockScript []byte, hashType SigHashType) ([]byte, error) {
+	if index < 0 || index >= len(tx.Inputs) {
+		return nil, fmt.Errorf("input %d does not exist", index)
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[index].Script = lockScript
+
+	switch hashType.base() {
+	case SigHashNone:
+		txCopy.Outputs = nil
+	case SigHashSingle:
+		if index >= len(txCopy.Outputs) {
+			return nil, fmt.Errorf("input %d has no output to sign with SIGHASH_SINGLE", index)
+		}
+
+		txCopy.Outputs = txCopy.Outputs[:index+1]
+
+		// outputs before the signed one can be anything
+		for i := 0; i < index; i++ {
+			txCopy.Outputs[i] = TxOutput{-1, nil}
+		}
+	}
+
+	if hashType.base() == SigHashNone || hashType.base() == SigHashSingle {
+		for i := range txCopy.Inputs {
+			if i != index {
+				txCopy.Inputs[i].Sequence = 0
+			}
+		}
+	}
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Inputs = txCopy.Inputs[index : index+1]
+	}
+
+	var e encoder
+
+	txCopy.encode(&e, true)
+	e.writeUint32(uint32(hashType))
+
+	hash := sha256.Sum256(e.buf.Bytes())
+
+	return hash[:], nil
+}
+
+// signInput returns signature of input at index spending output locked with lockScript,
+// which is signature of SignatureHash followed by hashType
+func (tx *Transaction) signInput(w *wallet.Wallet, index int, lockScript []byte, hashType SigHashType) ([]byte, error) {
+	if !hashType.isValid() {
+		return nil, fmt.Errorf("signature hash type %02x is not valid", byte(hashType))
+	}
+
+	hash, err := tx.SignatureHash(index, lockScript, hashType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signHash(w, hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signature, byte(hashType)), nil
+}
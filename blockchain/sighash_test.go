@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestParseSigHashType(t *testing.T) {
+	names := map[string]SigHashType{
+		"ALL":                 SigHashAll,
+		"NONE":                SigHashNone,
+		"SINGLE":              SigHashSingle,
+		"ALL|ANYONECANPAY":    SigHashAll | SigHashAnyoneCanPay,
+		"SINGLE|ANYONECANPAY": SigHashSingle | SigHashAnyoneCanPay,
+	}
+
+	for name, want := range names {
+		hashType, err := ParseSigHashType(name)
+
+		if err != nil || hashType != want {
+			t.Errorf("%s is parsed as %02x with error %v, want %02x", name, byte(hashType), err, byte(want))
+		}
+
+		if hashType.String() != name {
+			t.Errorf("%02x is named %s, want %s", byte(hashType), hashType, name)
+		}
+	}
+
+	for _, name := range []string{"ANYONECANPAY", "ALL|NONE", "EVERYTHING"} {
+		if _, err := ParseSigHashType(name); err == nil {
+			t.Errorf("%s is parsed", name)
+		}
+	}
+}
+
+// TestSignatureHash changes parts of transaction and checks which signature hash types commit to them
+func TestSignatureHash(t *testing.T) {
+	newTx := func() *Transaction {
+		return &Transaction{
+			Inputs:  []TxInput{{[]byte{0x01}, 0, nil, MaxSequence}, {[]byte{0x02}, 0, nil, MaxSequence}},
+			Outputs: []TxOutput{{10, []byte{0x51}}, {20, []byte{0x52}}},
+		}
+	}
+
+	changes := []struct {
+		name   string
+		change func(tx *Transaction)
+		// signed tells for ALL, NONE, SINGLE and ALL|ANYONECANPAY whether the change is signed
+		signed [4]bool
+	}{
+		{"signed output", func(tx *Transaction) { tx.Outputs[0].Value++ }, [4]bool{true, false, true, true}},
+		{"other output", func(tx *Transaction) { tx.Outputs[1].Value++ }, [4]bool{true, false, false, true}},
+		{"other input", func(tx *Transaction) { tx.Inputs[1].Out++ }, [4]bool{true, true, true, false}},
+		{"other input sequence", func(tx *Transaction) { tx.Inputs[1].Sequence-- }, [4]bool{true, false, false, false}},
+		{"added input", func(tx *Transaction) { tx.Inputs = append(tx.Inputs, TxInput{[]byte{0x03}, 0, nil, MaxSequence}) }, [4]bool{true, true, true, false}},
+		{"lock time", func(tx *Transaction) { tx.LockTime++ }, [4]bool{true, true, true, true}},
+	}
+
+	hashTypes := []SigHashType{SigHashAll, SigHashNone, SigHashSingle, SigHashAll | SigHashAnyoneCanPay}
+	lockScript := []byte{0x51}
+
+	for i, hashType := range hashTypes {
+		hash, err := newTx().SignatureHash(0, lockScript, hashType)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range changes {
+			tx := newTx()
+			c.change(tx)
+
+			changed, err := tx.SignatureHash(0, lockScript, hashType)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if signed := bytes.Compare(changed, hash) != 0; signed != c.signed[i] {
+				t.Errorf("%s signs %s: %t, want %t", hashType, c.name, signed, c.signed[i])
+			}
+		}
+
+		if i > 0 {
+			if other, _ := newTx().SignatureHash(0, lockScript, hashTypes[0]); bytes.Compare(other, hash) == 0 {
+				t.Errorf("%s signs the same hash as %s", hashType, hashTypes[0])
+			}
+		}
+	}
+
+	single := newTx()
+	single.Outputs = single.Outputs[:1]
+
+	if _, err := single.SignatureHash(1, lockScript, SigHashSingle); err == nil {
+		t.Error("input without output of the same index is signed with SIGHASH_SINGLE")
+	}
+}
+
+// TestAnyoneCanPay funds transaction by inputs of two parties, each signing only its own input
+func TestAnyoneCanPay(t *testing.T) {
+	useRegTest(t)
+
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	prevTXs := make(map[string]Transaction)
+
+	var inputs []TxInput
+
+	for _, w := range []*wallet.Wallet{alice, bob} {
+		prevTX := Transaction{Outputs: []TxOutput{*NewTXOutput(50, walletAddress(w))}}
+		prevTX.ID = prevTX.Hash()
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		inputs = append(inputs, TxInput{prevTX.ID, 0, nil, MaxSequence})
+	}
+
+	project := NewTXOutput(100, walletAddress(wallet.MakeWallet()))
+	tx := &Transaction{Inputs: inputs[:1], Outputs: []TxOutput{*project}}
+
+	if err := tx.SignWithHashType(alice, prevTXs, SigHashAll|SigHashAnyoneCanPay); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob adds his input after alice has signed, her signature stays valid
+	tx.Inputs = append(tx.Inputs, inputs[1])
+
+	if err := tx.SignWithHashType(bob, prevTXs, SigHashAll|SigHashAnyoneCanPay); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Verify(prevTXs); err != nil {
+		t.Fatalf("transaction funded by both parties is not verified: %s", err)
+	}
+
+	tx.Outputs[0].Value--
+
+	if err := tx.Verify(prevTXs); err == nil {
+		t.Error("transaction with changed output is verified")
+	}
+}
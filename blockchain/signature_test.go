@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// highS returns signature with s replaced by N-s, which is valid ECDSA signature as well
+func highS(signature []byte) []byte {
+	s := new(big.Int).SetBytes(signature[signatureLength/2 : signatureLength])
+	s.Sub(elliptic.P256().Params().N, s)
+
+	malleated := append([]byte{}, signature...)
+	s.FillBytes(malleated[signatureLength/2 : signatureLength])
+
+	return malleated
+}
+
+func TestSignHashLowS(t *testing.T) {
+	w := wallet.MakeWallet()
+	hash := make([]byte, 32)
+
+	for i := 0; i < 16; i++ {
+		hash[0] = byte(i)
+		signature, err := signHash(w, hash)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checkSignatureEncoding(signature, w.PublicKey); err != nil {
+			t.Fatalf("signature %x is not canonical: %s", signature, err)
+		}
+
+		if !checkSignature(signature, w.PublicKey, hash) {
+			t.Fatalf("signature %x is not verified", signature)
+		}
+
+		if malleated := highS(signature); checkSignature(malleated, w.PublicKey, hash) {
+			t.Fatalf("signature %x with high s is verified", malleated)
+		}
+	}
+
+	if err := checkSignatureEncoding(make([]byte, signatureLength-1), w.PublicKey); err == nil {
+		t.Error("short signature is canonical")
+	}
+}
+
+// TestTransactionIDIgnoresSignatures changes signature of mined transaction,
+// which keeps its ID but is caught by the witness root of the block
+func TestTransactionIDIgnoresSignatures(t *testing.T) {
+	useRegTest(t)
+
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, "witness", owner, 100)
+	miner := walletAddress(wallet.MakeWallet())
+	genesis := genesisBlock(t, chain)
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{genesis.Transactions[0].ID, 0, nil, MaxSequence}},
+		Outputs: []TxOutput{*NewTXOutput(100, walletAddress(wallet.MakeWallet()))},
+	}
+	tx.ID = tx.Hash()
+	unsignedID := tx.ID
+
+	chain.SignTransaction(tx, owner)
+	signed := tx.Serialize()
+
+	if bytes.Compare(tx.Hash(), unsignedID) != 0 {
+		t.Fatal("signing changes transaction ID")
+	}
+
+	block := buildBlock(t, chain, genesis, miner, 0, tx)
+
+	// third party can only turn the signature into high s one, which is not canonical
+	ops, _ := ParseScript(tx.Inputs[0].Script)
+	tx.Inputs[0].Script = P2PKHUnlockScript(highS(ops[0].Data), ops[1].Data)
+
+	if err := chain.checkTransaction(tx); !rejected(err, RejectScriptFailed) {
+		t.Errorf("transaction with high s signature is checked with error %v, want %s", err, RejectScriptFailed)
+	}
+
+	// owner can sign again, which gives another valid signature
+	chain.SignTransaction(tx, owner)
+
+	if bytes.Compare(tx.Serialize(), signed) == 0 {
+		t.Fatal("signatures are the same")
+	}
+
+	if bytes.Compare(tx.Hash(), unsignedID) != 0 {
+		t.Error("transaction signed again has another ID")
+	}
+
+	*block.Transactions[1] = *tx
+
+	if err := ValidateBlock(chain.Engine, block); !rejected(err, RejectBadWitnessRoot) {
+		t.Errorf("block with changed signature is validated with error %v, want %s", err, RejectBadWitnessRoot)
+	}
+}
+
+// TestMixedKeyTypes spends outputs of P256 and Ed25519 keys in one transaction
+func TestMixedKeyTypes(t *testing.T) {
+	useRegTest(t)
+
+	p256, ed := wallet.MakeWallet(), wallet.MakeWalletOfType(wallet.Ed25519)
+	chain := newTestChain(t, "keys", p256, 100)
+	miner := walletAddress(wallet.MakeWallet())
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	fund, err := NewTransaction(p256, walletAddress(ed), 60, 1, &UTXOSet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineTx(t, chain, miner, fund)
+
+	tx := &Transaction{Outputs: []TxOutput{*NewTXOutput(98, walletAddress(wallet.MakeWallet()))}}
+
+	for out := range fund.Outputs {
+		tx.Inputs = append(tx.Inputs, TxInput{fund.ID, out, nil, MaxSequence})
+	}
+
+	tx.ID = tx.Hash()
+	chain.SignTransaction(tx, p256)
+
+	if chain.VerifyTransaction(tx) {
+		t.Fatal("transaction signed by one of two keys is verified")
+	}
+
+	chain.SignTransaction(tx, ed)
+
+	for _, in := range tx.Inputs {
+		ops, _ := ParseScript(in.Script)
+
+		if keyType, _ := wallet.PublicKeyType(ops[1].Data); len(ops[0].Data) != signatureLength+1 {
+			t.Errorf("%s signature is %d bytes", keyType, len(ops[0].Data))
+		}
+	}
+
+	mineTx(t, chain, miner, tx)
+
+	if got := balance(chain, walletAddress(ed)); got != 0 {
+		t.Errorf("balance of ed25519 key is %d after spend", got)
+	}
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// MedianTimeBlocks is number of previous blocks used to calculate median time past
+	MedianTimeBlocks = 11
+	// MaxFutureBlockTime is how many seconds block time may be ahead of the network adjusted time
+	MaxFutureBlockTime = 10 * 60
+	// maxTimeOffset limits how far peers can move the node clock in seconds
+	maxTimeOffset = 70 * 60
+	// minTimeSamples is number of clocks, the local one included, needed before the node clock
+	// is adjusted, so a few peers can't move it on their own
+	minTimeSamples = 5
+	// maxTimeSamples limits number of peers whose clocks are remembered
+	maxTimeSamples = 200
+)
+
+// MedianTime is the node clock adjusted by the median offset of peer clocks
+type MedianTime struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	offset  int64
+}
+
+func NewMedianTime() *MedianTime {
+	return &MedianTime{offsets: make(map[string]int64)}
+}
+
+// AddTimeSample remembers difference between peer time and local time,
+// only the latest sample of every peer is taken into account. Once maxTimeSamples
+// peers are known, samples of new peers are ignored
+func (m *MedianTime) AddTimeSample(peer string, peerTime int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, known := m.offsets[peer]; !known && len(m.offsets) >= maxTimeSamples {
+		return
+	}
+
+	m.offsets[peer] = peerTime - time.Now().Unix()
+
+	// local clock takes part in the median with zero offset
+	offsets := []int64{0}
+
+	for _, offset := range m.offsets {
+		offsets = append(offsets, offset)
+	}
+
+	if len(offsets) < minTimeSamples {
+		m.offset = 0
+		return
+	}
+
+	m.offset = median(offsets)
+
+	if m.offset > maxTimeOffset || m.offset < -maxTimeOffset {
+		m.offset = 0
+	}
+}
+
+// AdjustedTime returns local unix time shifted by the median offset of peers
+func (m *MedianTime) AdjustedTime() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return time.Now().Unix() + m.offset
+}
+
+func median(values []int64) int64 {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	return values[len(values)/2]
+}
+
+// medianTimePast returns median timestamp of the last MedianTimeBlocks blocks ending with block
+func medianTimePast(txn *badger.Txn, block *Block) (int64, error) {
+	var timestamps []int64
+
+	for i := 0; i < MedianTimeBlocks; i++ {
+		timestamps = append(timestamps, block.Timestamp)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+
+		var err error
+
+		if block, err = getBlock(txn, block.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+
+	return median(timestamps), nil
+}
+
+// validateTimestamp checks that block is newer than median time past of its parent
+func validateTimestamp(txn *badger.Txn, block, parent *Block) error {
+	pastMedian, err := medianTimePast(txn, parent)
+
+	if err != nil {
+		return err
+	}
+
+	if block.Timestamp <= pastMedian {
+		return rejectf(RejectTimeTooOld, "block time %d is not after median time past %d", block.Timestamp, pastMedian)
+	}
+
+	return nil
+}
+
+// validateFutureDrift checks that block is not too far ahead of network adjusted time
+func validateFutureDrift(block *Block, adjustedTime int64) error {
+	if block.Timestamp > adjustedTime+MaxFutureBlockTime {
+		return rejectf(RejectTimeTooNew, "block time %d is too far ahead of network time %d", block.Timestamp, adjustedTime)
+	}
+
+	return nil
+}
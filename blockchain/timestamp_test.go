@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Dimashey/blockchain/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+// offsetNear tells whether clock offset is off by at most a second passing during the test
+func offsetNear(m *MedianTime, want int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.offset >= want-1 && m.offset <= want+1
+}
+
+func TestMedianTime(t *testing.T) {
+	m := NewMedianTime()
+
+	for i := 1; i < minTimeSamples-1; i++ {
+		m.AddTimeSample(fmt.Sprintf("peer %d", i), time.Now().Unix()+1000)
+	}
+
+	if !offsetNear(m, 0) {
+		t.Fatalf("clock is moved by %d peers, offset %d", minTimeSamples-2, m.offset)
+	}
+
+	// a peer sending a new sample is counted once
+	m.AddTimeSample("peer 1", time.Now().Unix()+1000)
+
+	if !offsetNear(m, 0) {
+		t.Fatalf("clock is moved by a peer counted twice, offset %d", m.offset)
+	}
+
+	m.AddTimeSample("last peer", time.Now().Unix()+1000)
+
+	if !offsetNear(m, 1000) {
+		t.Fatalf("offset is %d with %d samples, want 1000", m.offset, minTimeSamples)
+	}
+
+	for i := 1; i < minTimeSamples-1; i++ {
+		m.AddTimeSample(fmt.Sprintf("peer %d", i), time.Now().Unix()+maxTimeOffset+1000)
+	}
+
+	m.AddTimeSample("last peer", time.Now().Unix()+maxTimeOffset+1000)
+
+	if !offsetNear(m, 0) {
+		t.Errorf("offset beyond the limit is %d, want 0", m.offset)
+	}
+}
+
+func TestMedianTimeSamplesLimit(t *testing.T) {
+	m := NewMedianTime()
+
+	for i := 0; i < maxTimeSamples; i++ {
+		m.AddTimeSample(fmt.Sprintf("peer %d", i), time.Now().Unix())
+	}
+
+	// new peers can't outvote the known ones
+	for i := 0; i < maxTimeSamples; i++ {
+		m.AddTimeSample(fmt.Sprintf("new peer %d", i), time.Now().Unix()+1000)
+	}
+
+	if len(m.offsets) != maxTimeSamples {
+		t.Errorf("%d peers are remembered, want %d", len(m.offsets), maxTimeSamples)
+	}
+
+	if !offsetNear(m, 0) {
+		t.Errorf("offset is %d, want 0", m.offset)
+	}
+}
+
+func TestValidateTimestamp(t *testing.T) {
+	useRegTest(t)
+
+	chain := newTestChain(t, "timestamp", wallet.MakeWallet(), 100)
+	miner := walletAddress(wallet.MakeWallet())
+
+	// timestamps of the last MedianTimeBlocks blocks go back and forth around their median 500
+	var parent *Block
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		for i := 0; i < MedianTimeBlocks+3; i++ {
+			timestamp := int64(100 + 100*(i%9))
+
+			if i%2 == 1 {
+				timestamp = 1000 - timestamp
+			}
+
+			var prevHash []byte
+
+			if parent != nil {
+				prevHash = parent.Hash
+			}
+
+			block := NewBlock([]*Transaction{CoinbaseTx(miner, "", i, 0)}, prevHash, i, 0, timestamp)
+			block.Hash = block.BlockHeader.Hash()
+
+			if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+				return err
+			}
+
+			parent = block
+		}
+
+		pastMedian, err := medianTimePast(txn, parent)
+
+		if err != nil {
+			return err
+		}
+
+		if pastMedian != 500 {
+			t.Errorf("median time past is %d, want 500", pastMedian)
+		}
+
+		timestamps := []struct {
+			timestamp int64
+			valid     bool
+		}{
+			{pastMedian - 1, false},
+			{pastMedian, false},
+			{pastMedian + 1, true},
+		}
+
+		for _, ts := range timestamps {
+			block := NewBlock(nil, parent.Hash, parent.Height+1, 0, ts.timestamp)
+			err := validateTimestamp(txn, block, parent)
+
+			if ts.valid && err != nil {
+				t.Errorf("block at %d is rejected: %s", ts.timestamp, err)
+			}
+
+			if !ts.valid && !rejected(err, RejectTimeTooOld) {
+				t.Errorf("block at %d is validated with error %v, want %s", ts.timestamp, err, RejectTimeTooOld)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateFutureDrift(t *testing.T) {
+	now := int64(1704067200)
+
+	if err := validateFutureDrift(&Block{BlockHeader: BlockHeader{Timestamp: now + MaxFutureBlockTime}}, now); err != nil {
+		t.Errorf("block at the drift limit is rejected: %s", err)
+	}
+
+	if err := validateFutureDrift(&Block{BlockHeader: BlockHeader{Timestamp: now + MaxFutureBlockTime + 1}}, now); !rejected(err, RejectTimeTooNew) {
+		t.Errorf("block beyond the drift limit is validated with error %v, want %s", err, RejectTimeTooNew)
+	}
+}
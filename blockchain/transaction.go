@@ -2,142 +2,269 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
-	"math/big"
 	"strings"
 
 	"github.com/Dimashey/blockchain/internal/util"
 	"github.com/Dimashey/blockchain/wallet"
 )
 
+var errNotEnoughFunds = errors.New("not enough funds")
+
 type Transaction struct {
 	ID      []byte
 	Inputs  []TxInput
 	Outputs []TxOutput
+	// LockTime is the height or, starting from LockTimeThreshold, the time
+	// transaction can't be included in block before, see IsFinal
+	LockTime uint32
 }
 
+// Serialize returns canonical encoding of transaction without its ID, see encoding.go
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	var e encoder
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+	tx.encode(&e, true)
 
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return encoded.Bytes()
+	return e.buf.Bytes()
 }
 
+// Hash returns transaction ID, which does not commit to unlocking scripts,
+// so it is known before transaction is signed and can't be changed by altering signatures
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
+	var e encoder
+
+	tx.encode(&e, false)
+	hash := sha256.Sum256(e.buf.Bytes())
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
+	return hash[:]
+}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+// WitnessHash returns hash of the whole transaction including unlocking scripts
+func (tx *Transaction) WitnessHash() []byte {
+	hash := sha256.Sum256(tx.Serialize())
 
 	return hash[:]
 }
 
+// TrimmedCopy returns copy of transaction with all unlocking scripts removed
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.Script})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// Sign unlocks every input spending pay-to-pubkey-hash output of w key
+func (tx *Transaction) Sign(w *wallet.Wallet, prevTXs map[string]Transaction) {
+	util.HandleError(tx.SignWithHashType(w, prevTXs, SigHashAll))
+}
+
+// SignWithHashType unlocks every input spending pay-to-pubkey-hash output of w key
+// with signatures of hashType. Inputs of other owners are left as they are,
+// so transaction can be signed by several parties
+func (tx *Transaction) SignWithHashType(w *wallet.Wallet, prevTXs map[string]Transaction, hashType SigHashType) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, in := range tx.Inputs {
 		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction does not exist")
+			return errors.New("previous transaction does not exist")
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
+	lockScript := P2PKHScript(wallet.PublicHash(w.PublicKey))
 
-	for inId, in := range txCopy.Inputs {
+	for inId, in := range tx.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(in.ID)]
 
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) || !prevTX.Outputs[in.Out].IsLockedWith(lockScript) {
+			continue
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		util.HandleError(err)
+		signature, err := tx.signInput(w, inId, lockScript, hashType)
 
-		signature := append(r.Bytes(), s.Bytes()...)
+		if err != nil {
+			return err
+		}
 
-		tx.Inputs[inId].Signature = signature
+		tx.Inputs[inId].Script = P2PKHUnlockScript(signature, w.PublicKey)
 	}
+
+	return nil
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
+// SignMultisig adds signature of w key to every input spending output locked
+// to script hash of multisig redeemScript. Until enough signatures are collected
+// unlocking script holds a slot for signature of every key, empty ones are dropped
+// once the last required signature is added. It returns whether tx is fully signed
+func (tx *Transaction) SignMultisig(w *wallet.Wallet, prevTXs map[string]Transaction, redeemScript []byte) (bool, error) {
+	m, pubKeys, ok := ParseMultisigScript(redeemScript)
+
+	if !ok {
+		return false, errors.New("script is not a multisig script")
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction does not exist")
+	keyIndex := -1
+
+	for i, key := range pubKeys {
+		if bytes.Compare(key, w.PublicKey) == 0 {
+			keyIndex = i
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	if keyIndex < 0 {
+		return false, errors.New("key is not part of the multisig script")
+	}
+
+	lockScript := P2SHScript(wallet.PublicHash(redeemScript))
+	complete := true
 
 	for inId, in := range tx.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+		prevTX, found := prevTXs[hex.EncodeToString(in.ID)]
+
+		if !found {
+			return false, errors.New("previous transaction does not exist")
+		}
+
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) || !prevTX.Outputs[in.Out].IsLockedWith(lockScript) {
+			continue
+		}
+
+		slots := multisigSlots(in.Script, len(pubKeys))
+
+		if slots == nil {
+			// already has all required signatures
+			continue
+		}
+
+		signature, err := tx.signInput(w, inId, redeemScript, SigHashAll)
+
+		if err != nil {
+			return false, err
+		}
+
+		slots[keyIndex] = signature
+
+		var signatures [][]byte
+
+		for _, slot := range slots {
+			if len(slot) != 0 {
+				signatures = append(signatures, slot)
+			}
+		}
+
+		if len(signatures) >= m {
+			slots = signatures[:m]
+		} else {
+			complete = false
+		}
 
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
+		b := NewScriptBuilder()
 
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[:(sigLen / 2)])
+		for _, slot := range slots {
+			b.AddData(slot)
+		}
+
+		tx.Inputs[inId].Script = b.AddData(redeemScript).Script()
+	}
+
+	return complete, nil
+}
+
+// multisigSlots returns signature slots of partially signed multisig input
+// or nil when input is fully signed
+func multisigSlots(unlockScript []byte, n int) [][]byte {
+	slots := make([][]byte, n)
+
+	if len(unlockScript) == 0 {
+		return slots
+	}
+
+	ops, err := ParseScript(unlockScript)
+
+	if err != nil || len(ops) != n+1 {
+		return nil
+	}
+
+	empty := false
+
+	for i := range slots {
+		slots[i] = ops[i].Data
+		empty = empty || len(slots[i]) == 0
+	}
+
+	if !empty {
+		return nil
+	}
+
+	return slots
+}
+
+// Verify checks that every input unlocks the output of prevTXs it spends
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	var prevOuts []TxOutput
+
+	for inId, in := range tx.Inputs {
+		prevTX, found := prevTXs[hex.EncodeToString(in.ID)]
 
-		x := big.Int{}
-		y := big.Int{}
+		if !found {
+			return fmt.Errorf("input %d: previous transaction %x does not exist", inId, in.ID)
+		}
 
-		keyLen := len(in.PubKey)
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return fmt.Errorf("input %d: previous transaction %x has no output %d", inId, in.ID, in.Out)
+		}
 
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[:(keyLen / 2)])
+		prevOuts = append(prevOuts, prevTX.Outputs[in.Out])
+	}
 
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
+	return tx.VerifyScripts(prevOuts)
+}
 
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
+// VerifyOutputs checks that every input unlocks the output it spends,
+// prevOuts[i] is the output referenced by tx.Inputs[i]
+func (tx *Transaction) VerifyOutputs(prevOuts []TxOutput) bool {
+	return tx.VerifyScripts(prevOuts) == nil
+}
+
+// VerifyScripts runs unlocking script of every input together with locking script
+// of the output it spends and returns the first failure
+func (tx *Transaction) VerifyScripts(prevOuts []TxOutput) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	if len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("%d outputs are given for %d inputs", len(prevOuts), len(tx.Inputs))
+	}
+
+	for inId := range tx.Inputs {
+		if err := VerifyScript(tx, inId, prevOuts[inId]); err != nil {
+			return fmt.Errorf("input %d: %w", inId, err)
 		}
 	}
 
-	return true
+	return nil
 }
 
 func (tx Transaction) String() string {
@@ -148,14 +275,17 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", input.Script))
+		} else {
+			lines = append(lines, fmt.Sprintf("       Script:    %s", DisasmScript(input.Script)))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisasmScript(output.Script)))
 	}
 
 	return strings.Join(lines, "\n")
@@ -165,18 +295,19 @@ type TxOutput struct {
 	// Value in tokens assing to specific address
 	// Example 1 BTC
 	Value int
-	// User address hash to which token was sent.
-	PubKeyHash []byte
+	// Script locking tokens, see script.go
+	Script []byte
 }
 
+// Lock locks output to address, see LockScript
 func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := util.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	out.Script = LockScript(string(address))
 }
 
-func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+// IsLockedWith reports whether output is locked with lockScript,
+// which is LockScript of the owner address
+func (out *TxOutput) IsLockedWith(lockScript []byte) bool {
+	return bytes.Compare(out.Script, lockScript) == 0
 }
 
 func NewTXOutput(value int, address string) *TxOutput {
@@ -190,50 +321,193 @@ type TxInput struct {
 	// Reference to Tx from whic we get TxOut
 	ID []byte
 	// Index of TxOut. For exampe transaction can have 4 TxOut we need reference only one of them
-	Out       int
-	Signature []byte
-	// Simlar to TxOutput.PubKey but "unhashed" version
-	PubKey []byte
+	Out int
+	// Script unlocking the spent output, arbitrary data for coinbase
+	Script []byte
+	// Sequence is relative lock of the spent output, see locktime.go.
+	// When all inputs have MaxSequence transaction lock time is not checked
+	Sequence uint32
 }
 
-func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicHash(in.PubKey)
-
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
-}
-
-// CoinbaseTx create first transaction in blockchain network
-func CoinbaseTx(to, data string) *Transaction {
+// CoinbaseTx create first transaction of a block, it pays subsidy of block at height
+// together with fees of all other transactions in the block to miner
+func CoinbaseTx(to, data string, height, fees int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 24)
 		_, err := rand.Read(randData)
 		util.HandleError(err)
 
-		data = fmt.Sprintf("Coin to %s", to)
+		data = fmt.Sprintf("%x", randData)
 	}
 
-	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txOut := NewTXOutput(20, to)
+	txIn := TxInput{[]byte{}, -1, []byte(data), MaxSequence}
+	txOut := NewTXOutput(Subsidy(height)+fees, to)
 
-	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
-	tx.Hash()
+	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}, 0}
+	tx.ID = tx.Hash()
 
 	return &tx
 }
 
+// Fee returns difference between values of spent outputs and outputs of transaction,
+// prevOuts[i] is the output referenced by tx.Inputs[i]. Values are summed within MaxMoney,
+// transaction spending or paying more is rejected
+func Fee(tx *Transaction, prevOuts []TxOutput) (int, error) {
+	in, out := 0, 0
+
+	var ok bool
+
+	for _, prevOut := range prevOuts {
+		if in, ok = addMoney(in, prevOut.Value); !ok {
+			return 0, rejectf(RejectBadValue, "transaction %x spends more than %d", tx.ID, MaxMoney())
+		}
+	}
+
+	for _, txOut := range tx.Outputs {
+		if out, ok = addMoney(out, txOut.Value); !ok {
+			return 0, rejectf(RejectBadValue, "transaction %x pays more than %d", tx.ID, MaxMoney())
+		}
+	}
+
+	return in - out, nil
+}
+
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
+// NewTransaction sends amount to address, fee is left unspent between inputs
+// and outputs and is collected by miner of the block including transaction
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	tx, err := newSpend(fmt.Sprintf("%s", w.Address()), to, amount, fee, UTXO)
+
+	if err != nil {
+		return nil, err
+	}
+
+	UTXO.Blockchain.SignTransaction(tx, w)
+
+	return tx, nil
+}
+
+// NewReplaceableTransaction is NewTransaction which signals replaceability, so until it is mined
+// it can be replaced by a transaction spending the same outputs with higher fee
+func NewReplaceableTransaction(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	tx, err := newSpend(fmt.Sprintf("%s", w.Address()), to, amount, fee, UTXO)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for inId := range tx.Inputs {
+		tx.Inputs[inId].Sequence = ReplaceableSequence
+	}
+
+	tx.ID = tx.Hash()
+	UTXO.Blockchain.SignTransaction(tx, w)
+
+	return tx, nil
+}
+
+// NewMultisigTransaction sends amount from multisig address to address. Transaction
+// is not signed yet, it has to be signed by required number of keys with SignMultisigTransaction
+func NewMultisigTransaction(from, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	return newSpend(from, to, amount, fee, UTXO)
+}
+
+// NewTimelockTransaction sends amount from address of timelock redeemScript, see TimelockScript.
+// Transaction lock time or input sequences are set to the script lock, so it is valid
+// only once the lock is over
+func NewTimelockTransaction(w *wallet.Wallet, redeemScript []byte, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	op, lock, pubKeyHash, ok := ParseTimelockScript(redeemScript)
+
+	if !ok {
+		return nil, errors.New("script is not a timelock script")
+	}
+
+	if bytes.Compare(pubKeyHash, wallet.PublicHash(w.PublicKey)) != 0 {
+		return nil, errors.New("key can't unlock the timelock script")
+	}
+
+	tx, err := newSpend(fmt.Sprintf("%s", wallet.ScriptAddress(redeemScript)), to, amount, fee, UTXO)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for inId := range tx.Inputs {
+		if op == OP_CHECKLOCKTIMEVERIFY {
+			// any sequence below MaxSequence enables lock time
+			tx.Inputs[inId].Sequence = MaxSequence - 1
+		} else {
+			tx.Inputs[inId].Sequence = uint32(lock)
+		}
+	}
+
+	if op == OP_CHECKLOCKTIMEVERIFY {
+		tx.LockTime = uint32(lock)
+	}
+
+	for inId := range tx.Inputs {
+		signature, err := tx.signInput(w, inId, redeemScript, SigHashAll)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tx.Inputs[inId].Script = NewScriptBuilder().
+			AddData(signature).
+			AddData(w.PublicKey).
+			AddData(redeemScript).
+			Script()
+	}
+
+	tx.ID = tx.Hash()
+
+	return tx, nil
+}
+
+// NewDataTransaction records data in the chain with DataScript output,
+// w pays only the fee as the output carries no value
+func NewDataTransaction(w *wallet.Wallet, data []byte, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	script, err := DataScript(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := newSpendOutputs(fmt.Sprintf("%s", w.Address()), []TxOutput{{0, script}}, fee, UTXO)
+
+	if err != nil {
+		return nil, err
+	}
+
+	UTXO.Blockchain.SignTransaction(tx, w)
+
+	return tx, nil
+}
+
+// newSpend returns unsigned transaction spending outputs of from address
+// and paying the change back to it
+func newSpend(from, to string, amount, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	return newSpendOutputs(from, []TxOutput{*NewTXOutput(amount, to)}, fee, UTXO)
+}
+
+// newSpendOutputs creates unsigned transaction paying outputs and fee from funds of address from,
+// the change goes back to from. It fails with errNotEnoughFunds when from can't pay them
+func newSpendOutputs(from string, outputs []TxOutput, fee int, UTXO *UTXOSet) (*Transaction, error) {
 	var inputs []TxInput
-	var outputs []TxOutput
 
-	pubKeyHash := wallet.PublicHash(w.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+	amount := 0
+
+	for _, out := range outputs {
+		amount += out.Value
+	}
+
+	acc, validOutputs := UTXO.FindSpendableOutputs(LockScript(from), amount+fee)
 
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: %s can spend %d, %d is needed", errNotEnoughFunds, from, acc, amount+fee)
 	}
 
 	for txid, outs := range validOutputs {
@@ -241,31 +515,23 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 		util.HandleError(err)
 
 		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
+			input := TxInput{txID, out, nil, MaxSequence}
 			inputs = append(inputs, input)
 		}
 	}
 
-	from := fmt.Sprintf("%s", w.Address())
-
-	outputs = append(outputs, *NewTXOutput(amount, to))
-
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from))
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, 0}
 	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
 
-	return &tx
+	return &tx, nil
 }
 
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&transaction)
+	transaction, err := DecodeTransaction(data)
 	util.HandleError(err)
 
 	return transaction
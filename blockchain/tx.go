@@ -1,32 +1,74 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
-
+	"github.com/Dimashey/blockchain/chaincfg"
 	"github.com/Dimashey/blockchain/internal/util"
 )
 
+// TxOutputs is the unspent part of a transaction as stored in the UTXO set.
+// Indexes keeps the original position of every output in the transaction,
+// so spent outputs can be removed without shifting the remaining ones.
 type TxOutputs struct {
 	Outputs []TxOutput
+	Indexes []int
+	// Height of the block containing transaction
+	Height   int
+	Coinbase bool
+}
+
+// IsMature reports whether outputs can be spent by block at height,
+// coinbase outputs have to be buried under chaincfg.Active.CoinbaseMaturity blocks first.
+// Genesis outputs are mature at once, every chain of the network shares genesis,
+// so no reorganization can take them away
+func (outs TxOutputs) IsMature(height int) bool {
+	return !outs.Coinbase || outs.Height == 0 || height-outs.Height >= chaincfg.Active.CoinbaseMaturity
 }
 
+// Serialize returns canonical encoding of outputs, see encoding.go
 func (outs TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
+	var e encoder
 
-	util.HandleError(err)
+	outs.encode(&e)
 
-	return buffer.Bytes()
+	return e.buf.Bytes()
 }
 
 func DeserializeOutputs(data []byte) TxOutputs {
 	var outputs TxOutputs
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
 
-	util.HandleError(err)
+	util.HandleError(decodeRecord(data, outputs.decode))
 
 	return outputs
 }
+
+// SpentOutput records an output consumed by a block, so it can be put back
+// into the UTXO set when the block is disconnected.
+type SpentOutput struct {
+	ID       []byte
+	Index    int
+	Output   TxOutput
+	Height   int
+	Coinbase bool
+}
+
+// BlockUndo holds every output spent by a block in the order they were spent.
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+// Serialize returns canonical encoding of undo data, see encoding.go
+func (undo BlockUndo) Serialize() []byte {
+	var e encoder
+
+	undo.encode(&e)
+
+	return e.buf.Bytes()
+}
+
+func DeserializeUndo(data []byte) BlockUndo {
+	var undo BlockUndo
+
+	util.HandleError(decodeRecord(data, undo.decode))
+
+	return undo
+}
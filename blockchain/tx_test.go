@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+func TestIsMature(t *testing.T) {
+	useRegTest(t)
+
+	maturity := chaincfg.Active.CoinbaseMaturity
+
+	outputs := []struct {
+		name   string
+		outs   TxOutputs
+		height int
+		want   bool
+	}{
+		{"spend output", TxOutputs{Height: 5}, 6, true},
+		{"genesis coinbase", TxOutputs{Height: 0, Coinbase: true}, 1, true},
+		{"fresh coinbase", TxOutputs{Height: 5, Coinbase: true}, 6, false},
+		{"coinbase a block before maturity", TxOutputs{Height: 5, Coinbase: true}, 5 + maturity - 1, false},
+		{"mature coinbase", TxOutputs{Height: 5, Coinbase: true}, 5 + maturity, true},
+	}
+
+	for _, o := range outputs {
+		if got := o.outs.IsMature(o.height); got != o.want {
+			t.Errorf("%s at height %d is mature: %t, want %t", o.name, o.height, got, o.want)
+		}
+	}
+}
+
+// TestCoinbaseMaturity spends block reward once it is buried under enough blocks
+func TestCoinbaseMaturity(t *testing.T) {
+	useRegTest(t)
+
+	miner := wallet.MakeWallet()
+	chain := newTestChain(t, "maturity", wallet.MakeWallet(), 100)
+
+	blocks, err := chain.Generate(context.Background(), 1, walletAddress(miner))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reward := blocks[0].Transactions[0]
+	spend := &Transaction{
+		Inputs:  []TxInput{{reward.ID, 0, nil, MaxSequence}},
+		Outputs: []TxOutput{*NewTXOutput(reward.Outputs[0].Value, walletAddress(wallet.MakeWallet()))},
+	}
+	spend.ID = spend.Hash()
+	chain.SignTransaction(spend, miner)
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+
+	if _, err := NewTransaction(miner, walletAddress(wallet.MakeWallet()), 1, 0, &UTXOSet); !errors.Is(err, errNotEnoughFunds) {
+		t.Errorf("immature reward is spent by wallet with error %v", err)
+	}
+
+	coinbase := CoinbaseTx(walletAddress(miner), "", 2, 0)
+
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, spend}); !rejected(err, RejectImmatureCoinbase) {
+		t.Errorf("immature reward is mined with error %v, want %s", err, RejectImmatureCoinbase)
+	}
+
+	tip, err := chain.GetBlock(chain.Tip())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.AddBlock(buildBlock(t, chain, &tip, walletAddress(miner), 0, spend)); !rejected(err, RejectImmatureCoinbase) {
+		t.Errorf("block spending immature reward is added with error %v, want %s", err, RejectImmatureCoinbase)
+	}
+
+	if _, err := chain.Generate(context.Background(), chaincfg.Active.CoinbaseMaturity-1, walletAddress(miner)); err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase = CoinbaseTx(walletAddress(miner), "", chain.GetBestHeight()+1, 0)
+
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, spend}); err != nil {
+		t.Errorf("mature reward is not mined: %s", err)
+	}
+}
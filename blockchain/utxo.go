@@ -3,6 +3,7 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/Dimashey/blockchain/internal/util"
@@ -18,86 +19,264 @@ type UTXOSet struct {
 	Blockchain *Chain
 }
 
+var undoPrefix = []byte("undo-")
+
+// prefixedKey builds a fresh key, so keys held by a pending badger transaction
+// never share the prefix backing array
+func prefixedKey(prefix, key []byte) []byte {
+	res := make([]byte, 0, len(prefix)+len(key))
+	res = append(res, prefix...)
+
+	return append(res, key...)
+}
+
+// Reindex rebuilds the UTXO set and the undo data by replaying the main chain
+// from the genesis block up to the current tip
 func (u UTXOSet) Reindex() {
 	db := u.Blockchain.Database
 
 	u.DeleteByPrefix(utxoPrefix)
+	u.DeleteByPrefix(undoPrefix)
 
-	UTXO := u.Blockchain.FindUnspentTransactions()
+	hashes := u.Blockchain.GetBlocksHashes()
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
+	for i := len(hashes) - 1; i >= 0; i-- {
+		err := db.Update(func(txn *badger.Txn) error {
+			block, err := getBlock(txn, hashes[i])
 
 			if err != nil {
 				return err
 			}
 
-			key = append(utxoPrefix, key...)
+			return connectBlock(txn, block)
+		})
 
-			err = txn.Set(key, outs.Serialize())
-			util.HandleError(err)
-		}
+		util.HandleError(err)
+	}
+}
 
-		return nil
+// Update applies block to the UTXO set
+func (u *UTXOSet) Update(block *Block) {
+	db := u.Blockchain.Database
+
+	err := db.Update(func(txn *badger.Txn) error {
+		return connectBlock(txn, block)
 	})
 
 	util.HandleError(err)
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
+// connectBlock removes outputs spent by block from the UTXO set, adds the new ones
+// and stores the spent outputs as undo data for disconnectBlock.
+// Inputs are validated against the outputs they spend on the way.
+func connectBlock(txn *badger.Txn, block *Block) error {
+	undo := BlockUndo{}
+	fees := 0
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-
-					util.HandleError(err)
-
-					v, err := item.ValueCopy(nil)
-					util.HandleError(err)
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
-				}
+	var parent *Block
 
-				newOutputs := TxOutputs{}
+	if len(block.PrevHash) != 0 {
+		var err error
 
-				for _, out := range tx.Outputs {
-					newOutputs.Outputs = append(newOutputs.Outputs, out)
-				}
+		if parent, err = getBlock(txn, block.PrevHash); err != nil {
+			return err
+		}
+	}
 
-				txID := append(utxoPrefix, tx.ID...)
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			var prevOuts []TxOutput
+			var coinHeights []int
 
-				if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-					log.Panic(err)
+			for _, in := range tx.Inputs {
+				spent, err := spendOutput(txn, in.ID, in.Out, block.Height)
+
+				if err != nil {
+					return err
 				}
+
+				prevOuts = append(prevOuts, spent.Output)
+				coinHeights = append(coinHeights, spent.Height)
+				undo.Spent = append(undo.Spent, spent)
+			}
+
+			if parent == nil {
+				return rejectf(RejectMissingInputs, "genesis block can't spend outputs")
+			}
+
+			if err := validateLockTimes(txn, tx, coinHeights, parent); err != nil {
+				return err
+			}
+
+			fee, err := validateSpends(tx, prevOuts)
+
+			if err != nil {
+				return err
+			}
+
+			var ok bool
+
+			if fees, ok = addMoney(fees, fee); !ok {
+				return rejectf(RejectBadValue, "block fees are more than %d", MaxMoney())
 			}
 		}
 
-		return nil
-	})
+		newOutputs := TxOutputs{Height: block.Height, Coinbase: tx.IsCoinbase()}
 
-	util.HandleError(err)
+		for outIdx, out := range tx.Outputs {
+			// outputs which can't be spent would only bloat the set
+			if IsUnspendable(out.Script) {
+				continue
+			}
+
+			newOutputs.Outputs = append(newOutputs.Outputs, out)
+			newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
+		}
+
+		if len(newOutputs.Outputs) == 0 {
+			continue
+		}
+
+		if err := txn.Set(prefixedKey(utxoPrefix, tx.ID), newOutputs.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	if len(block.PrevHash) != 0 {
+		if err := validateCoinbase(block, fees); err != nil {
+			return err
+		}
+	}
+
+	return txn.Set(prefixedKey(undoPrefix, block.Hash), undo.Serialize())
+}
+
+// disconnectBlock reverts connectBlock: outputs created by block are removed
+// and outputs spent by it are restored from the undo data
+func disconnectBlock(txn *badger.Txn, block *Block) error {
+	item, err := txn.Get(prefixedKey(undoPrefix, block.Hash))
+
+	if err != nil {
+		return fmt.Errorf("undo data for block %x is not found, run reindexutxo", block.Hash)
+	}
+
+	undoData, err := item.ValueCopy(nil)
+
+	if err != nil {
+		return err
+	}
+
+	spent := DeserializeUndo(undoData).Spent
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		if err := txn.Delete(prefixedKey(utxoPrefix, tx.ID)); err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			restored := spent[len(spent)-1]
+			spent = spent[:len(spent)-1]
+
+			if err := restoreOutput(txn, restored); err != nil {
+				return err
+			}
+		}
+	}
+
+	return txn.Delete(prefixedKey(undoPrefix, block.Hash))
+}
+
+// spendOutput removes output from the UTXO set on behalf of block at height
+func spendOutput(txn *badger.Txn, txID []byte, index, height int) (SpentOutput, error) {
+	key := prefixedKey(utxoPrefix, txID)
+	item, err := txn.Get(key)
+
+	if err == badger.ErrKeyNotFound {
+		return SpentOutput{}, rejectf(RejectMissingInputs, "output %x:%d is missing or already spent", txID, index)
+	} else if err != nil {
+		return SpentOutput{}, err
+	}
+
+	v, err := item.ValueCopy(nil)
+
+	if err != nil {
+		return SpentOutput{}, err
+	}
+
+	outs := DeserializeOutputs(v)
+	updatedOuts := TxOutputs{Height: outs.Height, Coinbase: outs.Coinbase}
+	var spent *TxOutput
+
+	for i, out := range outs.Outputs {
+		if outs.Indexes[i] == index {
+			spent = &outs.Outputs[i]
+			continue
+		}
+
+		updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+		updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Indexes[i])
+	}
+
+	if spent == nil {
+		return SpentOutput{}, rejectf(RejectMissingInputs, "output %x:%d is missing or already spent", txID, index)
+	}
+
+	if !outs.IsMature(height) {
+		return SpentOutput{}, rejectf(RejectImmatureCoinbase, "coinbase output %x:%d from height %d is spent at height %d",
+			txID, index, outs.Height, height)
+	}
+
+	if len(updatedOuts.Outputs) == 0 {
+		err = txn.Delete(key)
+	} else {
+		err = txn.Set(key, updatedOuts.Serialize())
+	}
+
+	return SpentOutput{txID, index, *spent, outs.Height, outs.Coinbase}, err
+}
+
+func restoreOutput(txn *badger.Txn, spent SpentOutput) error {
+	key := prefixedKey(utxoPrefix, spent.ID)
+	outs := TxOutputs{Height: spent.Height, Coinbase: spent.Coinbase}
+
+	if item, err := txn.Get(key); err == nil {
+		v, err := item.ValueCopy(nil)
+
+		if err != nil {
+			return err
+		}
+
+		outs = DeserializeOutputs(v)
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	restored := TxOutputs{Height: outs.Height, Coinbase: outs.Coinbase}
+	inserted := false
+
+	for i, out := range outs.Outputs {
+		if !inserted && spent.Index < outs.Indexes[i] {
+			restored.Outputs = append(restored.Outputs, spent.Output)
+			restored.Indexes = append(restored.Indexes, spent.Index)
+			inserted = true
+		}
+
+		restored.Outputs = append(restored.Outputs, out)
+		restored.Indexes = append(restored.Indexes, outs.Indexes[i])
+	}
+
+	if !inserted {
+		restored.Outputs = append(restored.Outputs, spent.Output)
+		restored.Indexes = append(restored.Indexes, spent.Index)
+	}
+
+	return txn.Set(key, restored.Serialize())
 }
 
 func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
@@ -139,10 +318,10 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 				if err := deleteKeys(keysForDelete); err != nil {
 					log.Panic(err)
 				}
-			}
 
-			keysForDelete = make([][]byte, 0, collectSize)
-			keysCollected = 0
+				keysForDelete = make([][]byte, 0, collectSize)
+				keysCollected = 0
+			}
 		}
 
 		if keysCollected > 0 {
@@ -180,7 +359,7 @@ func (u UTXOSet) CountTransactions() int {
 // FindUTXO finds all unsped transaction outputs which belongs for address
 // FYI: UTXO it TxOutput which is not used by other input what means
 // they form user balance
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
+func (u UTXOSet) FindUTXO(lockScript []byte) []TxOutput {
 	var utxos []TxOutput
 	db := u.Blockchain.Database
 
@@ -199,7 +378,7 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 			outs := DeserializeOutputs(v)
 
 			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
+				if out.IsLockedWith(lockScript) {
 					utxos = append(utxos, out)
 				}
 			}
@@ -213,15 +392,162 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	return utxos
 }
 
+// FindBalance returns sum of outputs locked with lockScript which can be spent in the next block
+// and sum of coinbase outputs which are not mature yet
+func (u UTXOSet) FindBalance(lockScript []byte) (int, int) {
+	spendable, immature := 0, 0
+
+	db := u.Blockchain.Database
+	height := u.Blockchain.GetBestHeight() + 1
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+
+			util.HandleError(err)
+
+			outs := DeserializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				if !out.IsLockedWith(lockScript) {
+					continue
+				}
+
+				if outs.IsMature(height) {
+					spendable += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+		}
+
+		return nil
+	})
+
+	util.HandleError(err)
+
+	return spendable, immature
+}
+
+// IsMature reports whether all outputs spent by transaction can be spent by block at height
+func (u UTXOSet) IsMature(tx *Transaction, height int) bool {
+	mature := true
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		for _, in := range tx.Inputs {
+			item, err := txn.Get(prefixedKey(utxoPrefix, in.ID))
+
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			v, err := item.ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			if !DeserializeOutputs(v).IsMature(height) {
+				mature = false
+			}
+		}
+
+		return nil
+	})
+
+	util.HandleError(err)
+
+	return mature
+}
+
+// TotalValue returns sum of all unspent outputs, which is amount of tokens in circulation
+func (u UTXOSet) TotalValue() int {
+	total := 0
+	db := u.Blockchain.Database
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+
+			util.HandleError(err)
+
+			for _, out := range DeserializeOutputs(v).Outputs {
+				total += out.Value
+			}
+		}
+
+		return nil
+	})
+
+	util.HandleError(err)
+
+	return total
+}
+
+// FindOutputs returns outputs spent by transaction inputs in the same order as inputs
+func (u UTXOSet) FindOutputs(tx *Transaction) ([]TxOutput, error) {
+	var prevOuts []TxOutput
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		for _, in := range tx.Inputs {
+			item, err := txn.Get(prefixedKey(utxoPrefix, in.ID))
+
+			if err == badger.ErrKeyNotFound {
+				return fmt.Errorf("output %x:%d is missing or already spent", in.ID, in.Out)
+			} else if err != nil {
+				return err
+			}
+
+			v, err := item.ValueCopy(nil)
+
+			if err != nil {
+				return err
+			}
+
+			outs := DeserializeOutputs(v)
+			found := false
+
+			for i, out := range outs.Outputs {
+				if outs.Indexes[i] == in.Out {
+					prevOuts = append(prevOuts, out)
+					found = true
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("output %x:%d is missing or already spent", in.ID, in.Out)
+			}
+		}
+
+		return nil
+	})
+
+	return prevOuts, err
+}
+
 // FindSpendableOutputs recieve address to which token should be sent and amount of token
 // returns accumlated values of tokens which can be sent, and UTXOs
 // For example 6 tokens should be sent and sum all of UTXO is 7
 // So accumulated is equal to 7
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// Coinbase outputs which are not mature yet are skipped
+func (u UTXOSet) FindSpendableOutputs(lockScript []byte, amount int) (int, map[string][]int) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
 
 	db := u.Blockchain.Database
+	height := u.Blockchain.GetBestHeight() + 1
 
 	err := db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -240,10 +566,14 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
 
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+			if !outs.IsMature(height) {
+				continue
+			}
+
+			for i, out := range outs.Outputs {
+				if out.IsLockedWith(lockScript) && accumulated < amount {
 					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+					unspentOuts[txID] = append(unspentOuts[txID], outs.Indexes[i])
 				}
 			}
 		}
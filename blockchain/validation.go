@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// RejectCode is a short machine readable reason why block was rejected
+type RejectCode string
+
+const (
+	RejectInvalidPoW        RejectCode = "invalid-pow"
+	RejectBadPrevHash       RejectCode = "bad-prev-hash"
+	RejectBadHeight         RejectCode = "bad-height"
+	RejectBadDifficulty     RejectCode = "bad-difficulty"
+	RejectTimeTooOld        RejectCode = "time-too-old"
+	RejectTimeTooNew        RejectCode = "time-too-new"
+	RejectBadTxID           RejectCode = "bad-txid"
+	RejectBadMerkleRoot     RejectCode = "bad-merkle-root"
+	RejectBadWitnessRoot    RejectCode = "bad-witness-merkle-root"
+	RejectNoCoinbase        RejectCode = "no-coinbase"
+	RejectMultipleCoinbase  RejectCode = "multiple-coinbase"
+	RejectBadCoinbaseAmount RejectCode = "bad-coinbase-amount"
+	RejectDuplicateTx       RejectCode = "duplicate-tx"
+	RejectDoubleSpend       RejectCode = "double-spend"
+	RejectMissingInputs     RejectCode = "missing-inputs"
+	RejectImmatureCoinbase  RejectCode = "immature-coinbase"
+	RejectScriptFailed      RejectCode = "script-verify-failed"
+	RejectBadValue          RejectCode = "bad-value"
+	RejectBadSeal           RejectCode = "bad-seal"
+	RejectNonFinal          RejectCode = "non-final"
+	RejectSequenceLock      RejectCode = "sequence-lock"
+	RejectBadDataCarrier    RejectCode = "bad-datacarrier"
+	RejectWrongGenesis      RejectCode = "wrong-genesis"
+	RejectInvalidAncestor   RejectCode = "bad-prevblk"
+)
+
+// ValidationError is returned when block breaks one of consensus rules
+type ValidationError struct {
+	Code    RejectCode
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func rejectf(code RejectCode, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{code, fmt.Sprintf(format, args...)}
+}
+
+// ValidateBlock checks rules which do not depend on the rest of the chain:
+// block seal according to the consensus engine, transaction IDs, coinbase placement,
+// merkle roots, output values and data, duplicate transactions and double spends inside the block.
+func ValidateBlock(engine ConsensusEngine, block *Block) error {
+	if bytes.Compare(block.Hash, block.BlockHeader.Hash()) != 0 {
+		return rejectf(RejectBadSeal, "block hash %x does not match its header", block.Hash)
+	}
+
+	if err := engine.VerifySeal(block); err != nil {
+		return err
+	}
+
+	if bytes.Compare(block.MerkleRoot, block.HashTransactions()) != 0 {
+		return rejectf(RejectBadMerkleRoot, "merkle root %x does not match transactions", block.MerkleRoot)
+	}
+
+	if bytes.Compare(block.WitnessRoot, block.HashWitnesses()) != 0 {
+		return rejectf(RejectBadWitnessRoot, "witness merkle root %x does not match transactions", block.WitnessRoot)
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return rejectf(RejectNoCoinbase, "first transaction is not coinbase")
+	}
+
+	txIDs := make(map[string]bool)
+	spent := make(map[string]bool)
+
+	for i, tx := range block.Transactions {
+		txID := hex.EncodeToString(tx.ID)
+
+		if bytes.Compare(tx.ID, tx.Hash()) != 0 {
+			return rejectf(RejectBadTxID, "transaction %s does not match its contents", txID)
+		}
+
+		if txIDs[txID] {
+			return rejectf(RejectDuplicateTx, "transaction %s is included twice", txID)
+		}
+
+		txIDs[txID] = true
+
+		if i > 0 && tx.IsCoinbase() {
+			return rejectf(RejectMultipleCoinbase, "transaction %s is a second coinbase", txID)
+		}
+
+		if err := CheckOutputs(tx); err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, in := range tx.Inputs {
+			outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+
+			if spent[outpoint] {
+				return rejectf(RejectDoubleSpend, "output %s is spent twice in block", outpoint)
+			}
+
+			spent[outpoint] = true
+		}
+	}
+
+	return nil
+}
+
+// CheckOutputs checks output values and data outputs of tx, which don't depend on the chain.
+// Data outputs are never added to the UTXO set, so value sent to them would be lost
+func CheckOutputs(tx *Transaction) error {
+	txID := hex.EncodeToString(tx.ID)
+	total := 0
+
+	for _, out := range tx.Outputs {
+		if out.Value < 0 {
+			return rejectf(RejectBadValue, "transaction %s has negative output", txID)
+		}
+
+		var ok bool
+
+		if total, ok = addMoney(total, out.Value); !ok {
+			return rejectf(RejectBadValue, "transaction %s pays more than %d", txID, MaxMoney())
+		}
+
+		if len(out.Script) == 0 || Opcode(out.Script[0]) != OP_RETURN {
+			continue
+		}
+
+		if _, ok := ExtractData(out.Script); !ok {
+			return rejectf(RejectBadDataCarrier, "transaction %s has data output which is not a single push of at most %d bytes", txID, MaxDataCarrierSize)
+		}
+
+		if out.Value != 0 {
+			return rejectf(RejectBadDataCarrier, "transaction %s sends %d to data output", txID, out.Value)
+		}
+	}
+
+	return nil
+}
+
+// validateLink checks that block correctly extends its parent
+func validateLink(block, parent *Block) error {
+	if bytes.Compare(block.PrevHash, parent.Hash) != 0 {
+		return rejectf(RejectBadPrevHash, "block %x does not point to %x", block.Hash, parent.Hash)
+	}
+
+	if block.Height != parent.Height+1 {
+		return rejectf(RejectBadHeight, "block height is %d, expected %d", block.Height, parent.Height+1)
+	}
+
+	return nil
+}
+
+// validateSpends checks transaction against outputs it spends, which are taken from the UTXO set,
+// and returns fee paid by transaction
+func validateSpends(tx *Transaction, prevOuts []TxOutput) (int, error) {
+	fee, err := Fee(tx, prevOuts)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if fee < 0 {
+		return 0, rejectf(RejectBadValue, "transaction %x spends %d more than it has", tx.ID, -fee)
+	}
+
+	if err := tx.VerifyScripts(prevOuts); err != nil {
+		return 0, rejectf(RejectScriptFailed, "transaction %x is not unlocked: %s", tx.ID, err)
+	}
+
+	return fee, nil
+}
+
+// validateCoinbase checks that coinbase pays no more than block subsidy and collected fees
+func validateCoinbase(block *Block, fees int) error {
+	coinbaseValue := 0
+	allowed := Subsidy(block.Height) + fees
+
+	for _, out := range block.Transactions[0].Outputs {
+		var ok bool
+
+		if coinbaseValue, ok = addMoney(coinbaseValue, out.Value); !ok {
+			return rejectf(RejectBadCoinbaseAmount, "coinbase pays more than %d", MaxMoney())
+		}
+	}
+
+	if coinbaseValue > allowed {
+		return rejectf(RejectBadCoinbaseAmount, "coinbase pays %d, allowed %d", coinbaseValue, allowed)
+	}
+
+	return nil
+}
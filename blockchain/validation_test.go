@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// quarter is a value four of which wrap int around to 0
+const quarter = 1 << (strconv.IntSize - 2)
+
+func rejected(err error, code RejectCode) bool {
+	invalid, ok := err.(*ValidationError)
+
+	return ok && invalid.Code == code
+}
+
+func TestFee(t *testing.T) {
+	useRegTest(t)
+
+	to := walletAddress(wallet.MakeWallet())
+	tx := &Transaction{Outputs: []TxOutput{*NewTXOutput(30, to), *NewTXOutput(60, to)}}
+
+	fee, err := Fee(tx, []TxOutput{*NewTXOutput(50, to), *NewTXOutput(50, to)})
+
+	if err != nil || fee != 10 {
+		t.Errorf("fee is %d with error %v, want 10", fee, err)
+	}
+
+	overflowing := &Transaction{}
+
+	for i := 0; i < 4; i++ {
+		overflowing.Outputs = append(overflowing.Outputs, *NewTXOutput(quarter, to))
+	}
+
+	if _, err := Fee(overflowing, nil); !rejected(err, RejectBadValue) {
+		t.Errorf("fee of outputs wrapping to 0 is computed with error %v, want %s", err, RejectBadValue)
+	}
+
+	if _, err := Fee(tx, overflowing.Outputs); !rejected(err, RejectBadValue) {
+		t.Errorf("fee of inputs wrapping to 0 is computed with error %v, want %s", err, RejectBadValue)
+	}
+}
+
+func TestValidateBlockRejectsOverflowingOutputs(t *testing.T) {
+	useRegTest(t)
+
+	to := walletAddress(wallet.MakeWallet())
+	chain := newTestChain(t, "overflow", wallet.MakeWallet(), 100)
+	genesis := genesisBlock(t, chain)
+
+	tx := &Transaction{Inputs: []TxInput{{genesis.Transactions[0].ID, 0, nil, MaxSequence}}}
+
+	for i := 0; i < 4; i++ {
+		tx.Outputs = append(tx.Outputs, *NewTXOutput(quarter, to))
+	}
+
+	tx.ID = tx.Hash()
+
+	block := buildBlock(t, chain, genesis, to, 0, tx)
+
+	if err := ValidateBlock(chain.Engine, block); !rejected(err, RejectBadValue) {
+		t.Errorf("block with outputs wrapping to 0 is validated with error %v, want %s", err, RejectBadValue)
+	}
+
+	large := &Transaction{Inputs: tx.Inputs, Outputs: []TxOutput{*NewTXOutput(MaxMoney()/2+1, to), *NewTXOutput(MaxMoney()/2+1, to)}}
+	large.ID = large.Hash()
+
+	if err := ValidateBlock(chain.Engine, buildBlock(t, chain, genesis, to, 0, large)); !rejected(err, RejectBadValue) {
+		t.Errorf("block with outputs above MaxMoney is validated with error %v, want %s", err, RejectBadValue)
+	}
+}
+
+func TestValidateCoinbase(t *testing.T) {
+	useRegTest(t)
+
+	miner := walletAddress(wallet.MakeWallet())
+	block := &Block{BlockHeader: BlockHeader{Height: 1}}
+
+	cases := []struct {
+		name  string
+		value int
+		fees  int
+		valid bool
+	}{
+		{"subsidy", Subsidy(1), 0, true},
+		{"subsidy and fees", Subsidy(1) + 5, 5, true},
+		{"more than subsidy and fees", Subsidy(1) + 6, 5, false},
+		{"above MaxMoney", MaxMoney() + 1, MaxMoney(), false},
+	}
+
+	for _, c := range cases {
+		block.Transactions = []*Transaction{CoinbaseTx(miner, "", 1, 0)}
+		block.Transactions[0].Outputs[0].Value = c.value
+
+		err := validateCoinbase(block, c.fees)
+
+		if c.valid && err != nil {
+			t.Errorf("%s: coinbase is rejected: %s", c.name, err)
+		}
+
+		if !c.valid && !rejected(err, RejectBadCoinbaseAmount) {
+			t.Errorf("%s: coinbase is validated with error %v, want %s", c.name, err, RejectBadCoinbaseAmount)
+		}
+	}
+
+	overflowing := CoinbaseTx(miner, "", 1, 0)
+
+	for i := 0; i < 4; i++ {
+		overflowing.Outputs = append(overflowing.Outputs, *NewTXOutput(quarter, miner))
+	}
+
+	block.Transactions = []*Transaction{overflowing}
+
+	if err := validateCoinbase(block, 0); !rejected(err, RejectBadCoinbaseAmount) {
+		t.Errorf("coinbase with outputs wrapping to 0 is validated with error %v, want %s", err, RejectBadCoinbaseAmount)
+	}
+}
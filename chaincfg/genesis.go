@@ -0,0 +1,44 @@
+package chaincfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// GenesisAlloc premines Amount tokens to Address in the genesis block
+type GenesisAlloc struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// Genesis is genesis file of a private network. Nodes created with the same file
+// share the genesis block and can sync with each other, but not with nodes of the public network
+type Genesis struct {
+	Alloc []GenesisAlloc `json:"alloc"`
+}
+
+// LoadGenesis reads genesis file at path, e.g.
+//
+//	{"alloc": [{"address": "1AfiRKTxC7GC8GkTNPjCSxkMXQVnkhmJ5B", "amount": 1000}]}
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var genesis Genesis
+
+	if err := json.Unmarshal(data, &genesis); err != nil {
+		return nil, fmt.Errorf("genesis file %s is malformed: %s", path, err)
+	}
+
+	for _, alloc := range genesis.Alloc {
+		if alloc.Amount <= 0 {
+			return nil, fmt.Errorf("genesis allocation to %s has to be positive, got %d", alloc.Address, alloc.Amount)
+		}
+	}
+
+	return &genesis, nil
+}
@@ -0,0 +1,41 @@
+package chaincfg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeGenesis(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadGenesis(t *testing.T) {
+	genesis, err := LoadGenesis(writeGenesis(t, `{"alloc": [{"address": "1AfiRKTxC7GC8GkTNPjCSxkMXQVnkhmJ5B", "amount": 1000}]}`))
+
+	if err != nil || len(genesis.Alloc) != 1 || genesis.Alloc[0] != (GenesisAlloc{"1AfiRKTxC7GC8GkTNPjCSxkMXQVnkhmJ5B", 1000}) {
+		t.Errorf("genesis file is loaded as %+v with error %v", genesis, err)
+	}
+
+	malformed := []string{
+		`{"alloc": [{"address": "1AfiRKTxC7GC8GkTNPjCSxkMXQVnkhmJ5B", "amount": 0}]}`,
+		`{"alloc": [{"address": "1AfiRKTxC7GC8GkTNPjCSxkMXQVnkhmJ5B", "amount": -5}]}`,
+		`{"alloc": `,
+	}
+
+	for _, content := range malformed {
+		if genesis, err := LoadGenesis(writeGenesis(t, content)); err == nil {
+			t.Errorf("genesis file %s is loaded as %+v", content, genesis)
+		}
+	}
+
+	if _, err := LoadGenesis(filepath.Join(t.TempDir(), "genesis.json")); err == nil {
+		t.Error("missing genesis file is loaded")
+	}
+}
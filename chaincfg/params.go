@@ -0,0 +1,174 @@
+package chaincfg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChainParams define a network. Nodes of different networks don't talk to each other,
+// keep their data in separate directories and don't accept addresses of each other
+type ChainParams struct {
+	// Name selects the network with -network flag
+	Name string
+	// Magic starts every message sent between nodes, messages of other networks are dropped
+	Magic [4]byte
+	// DefaultPort is the port node listens on when NODE_ID is not set
+	DefaultPort string
+	// Seeds are nodes new node connects to first
+	Seeds []string
+	// DataDir keeps blocks and wallets of the network
+	DataDir string
+
+	// PubKeyHashVersion is the first byte of address of a single P256 key
+	PubKeyHashVersion byte
+	// ScriptHashVersion is the first byte of address of a script, e.g. multisig
+	ScriptHashVersion byte
+	// Ed25519PubKeyHashVersion is the first byte of address of a single Ed25519 key.
+	// Outputs are locked to key addresses of both types the same way,
+	// the key type is told by the public key revealed when they are spent
+	Ed25519PubKeyHashVersion byte
+
+	// GenesisData is the coinbase data of the genesis block
+	GenesisData string
+	// GenesisTimestamp is the fixed time of the genesis block
+	GenesisTimestamp int64
+	// GenesisHash is hash of the genesis block built without genesis file
+	GenesisHash string
+	// Difficulty is number of leading zero bits of the genesis block target
+	Difficulty int
+	// PowLimitBits is the lowest difficulty in leading zero bits a block may have
+	PowLimitBits int
+	// RetargetInterval is number of blocks after which difficulty is adjusted
+	RetargetInterval int
+	// TargetBlockInterval is desired time between blocks in seconds
+	TargetBlockInterval int
+	// NoRetargeting keeps difficulty of the genesis block forever
+	NoRetargeting bool
+	// MineBlocksOnDemand allows generating blocks at will, which is only sensible
+	// when proof-of-work is trivial and blocks have no value
+	MineBlocksOnDemand bool
+
+	// InitialSubsidy is amount of new tokens paid to miner for every block of the first era
+	InitialSubsidy int
+	// HalvingInterval is number of blocks after which subsidy is halved
+	HalvingInterval int
+	// TerminalSubsidy is the lowest subsidy, once halving gets below it subsidy stays equal to it.
+	// Zero means that emission stops and total supply is capped
+	TerminalSubsidy int
+	// CoinbaseMaturity is number of blocks coinbase outputs have to wait before they can be spent,
+	// so mined rewards which can disappear with reorganization are not spent too early
+	CoinbaseMaturity int
+	// MaxMoney is the most tokens an output or all outputs of a transaction may hold,
+	// it is above premine and emission together so sums of values never overflow
+	MaxMoney int
+}
+
+// MainNetParams are parameters of the main network, its data stays where it was kept
+// before networks were introduced
+var MainNetParams = ChainParams{
+	Name:        "mainnet",
+	Magic:       [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+	DefaultPort: "3000",
+	Seeds:       []string{"localhost:3000"},
+	DataDir:     "./tmp",
+
+	PubKeyHashVersion:        0x00,
+	ScriptHashVersion:        0x05,
+	Ed25519PubKeyHashVersion: 0x21,
+
+	GenesisData:         "First Transaction from Genesis",
+	GenesisTimestamp:    1704067200,
+	GenesisHash:         "00000c3d90618868690033275c6e93620b84e52e9c3e5334ef1570771cea3852",
+	Difficulty:          18,
+	PowLimitBits:        8,
+	RetargetInterval:    10,
+	TargetBlockInterval: 10,
+
+	InitialSubsidy:   20,
+	HalvingInterval:  210000,
+	TerminalSubsidy:  0,
+	CoinbaseMaturity: 10,
+	MaxMoney:         21000000,
+}
+
+// TestNetParams are parameters of the public test network, its coins have no value
+var TestNetParams = ChainParams{
+	Name:        "testnet",
+	Magic:       [4]byte{0x0b, 0x11, 0x09, 0x07},
+	DefaultPort: "13000",
+	Seeds:       []string{"localhost:13000"},
+	DataDir:     "./tmp/testnet",
+
+	PubKeyHashVersion:        0x6f,
+	ScriptHashVersion:        0xc4,
+	Ed25519PubKeyHashVersion: 0x5c,
+
+	GenesisData:         "First Transaction from Testnet Genesis",
+	GenesisTimestamp:    1704067200,
+	GenesisHash:         "00011e513525ccc750fd869e23b7aed20f81d52e944ec9a5af91e2d204f541ae",
+	Difficulty:          12,
+	PowLimitBits:        8,
+	RetargetInterval:    10,
+	TargetBlockInterval: 10,
+
+	InitialSubsidy:   20,
+	HalvingInterval:  210000,
+	TerminalSubsidy:  0,
+	CoinbaseMaturity: 10,
+	MaxMoney:         21000000,
+}
+
+// RegTestParams are parameters of the local regression test network,
+// blocks are mined instantly, so tests are not slowed down by proof-of-work
+var RegTestParams = ChainParams{
+	Name:        "regtest",
+	Magic:       [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+	DefaultPort: "23000",
+	Seeds:       []string{"localhost:23000"},
+	DataDir:     "./tmp/regtest",
+
+	PubKeyHashVersion:        0x6f,
+	ScriptHashVersion:        0xc4,
+	Ed25519PubKeyHashVersion: 0x5c,
+
+	GenesisData:         "First Transaction from Regtest Genesis",
+	GenesisTimestamp:    1704067200,
+	GenesisHash:         "3c31876066d1796f895533f6b63679c06c0fa321d317134b1a3c0b2a5aedd486",
+	Difficulty:          1,
+	PowLimitBits:        1,
+	RetargetInterval:    10,
+	TargetBlockInterval: 10,
+	NoRetargeting:       true,
+	MineBlocksOnDemand:  true,
+
+	InitialSubsidy:   20,
+	HalvingInterval:  150,
+	TerminalSubsidy:  0,
+	CoinbaseMaturity: 2,
+	MaxMoney:         21000000,
+}
+
+// Networks are all known networks
+var Networks = []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams}
+
+// Active are parameters of the network this process runs on, chosen with Select
+// before any chain, wallet or node is opened
+var Active = &MainNetParams
+
+// Select makes network with name active
+func Select(name string) (*ChainParams, error) {
+	for _, params := range Networks {
+		if params.Name == name {
+			Active = params
+			return params, nil
+		}
+	}
+
+	var names []string
+
+	for _, params := range Networks {
+		names = append(names, params.Name)
+	}
+
+	return nil, fmt.Errorf("unknown network %s, expected one of %s", name, strings.Join(names, ", "))
+}
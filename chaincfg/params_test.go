@@ -0,0 +1,43 @@
+package chaincfg
+
+import "testing"
+
+func TestSelect(t *testing.T) {
+	previous := Active
+	t.Cleanup(func() { Active = previous })
+
+	for _, network := range Networks {
+		params, err := Select(network.Name)
+
+		if err != nil || params != network || Active != network {
+			t.Errorf("%s is selected as %v with error %v", network.Name, params, err)
+		}
+	}
+
+	Active = &RegTestParams
+
+	if _, err := Select("simnet"); err == nil || Active != &RegTestParams {
+		t.Errorf("unknown network is selected with error %v", err)
+	}
+}
+
+// TestNetworksAreSeparate checks that no two networks share what keeps their nodes and data apart
+func TestNetworksAreSeparate(t *testing.T) {
+	for i, a := range Networks {
+		if a.PubKeyHashVersion == a.ScriptHashVersion || a.PubKeyHashVersion == a.Ed25519PubKeyHashVersion ||
+			a.ScriptHashVersion == a.Ed25519PubKeyHashVersion {
+			t.Errorf("%s address versions are not distinct", a.Name)
+		}
+
+		for _, b := range Networks[i+1:] {
+			if a.Name == b.Name || a.Magic == b.Magic || a.DefaultPort == b.DefaultPort ||
+				a.DataDir == b.DataDir || a.GenesisHash == b.GenesisHash || a.GenesisData == b.GenesisData {
+				t.Errorf("%s and %s share name, magic, port, data directory or genesis", a.Name, b.Name)
+			}
+		}
+	}
+
+	if MainNetParams.PubKeyHashVersion == TestNetParams.PubKeyHashVersion {
+		t.Error("test network addresses are valid on the main network")
+	}
+}
@@ -1,14 +1,22 @@
 package commandline
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Dimashey/blockchain/blockchain"
+	"github.com/Dimashey/blockchain/chaincfg"
 	"github.com/Dimashey/blockchain/internal/util"
 	"github.com/Dimashey/blockchain/network"
 	"github.com/Dimashey/blockchain/wallet"
@@ -21,19 +29,40 @@ func New() CommandLine {
 }
 
 func (cli *CommandLine) printUsage() {
-	fmt.Println("Usage")
+	fmt.Println("Usage: [-network mainnet|testnet|regtest] COMMAND")
+	fmt.Println(" NODE_ID env is the port node listens on, by default it is the port of the network")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
+	fmt.Println(" createblockchain -genesis FILE -consensus pow|poa -signers ADDRESSES creates a blockchain starting with the genesis block of the network. Genesis JSON FILE premines tokens of a private network, they can be spent at once. Without it tokens are only issued by mined blocks, see mine. With poa consensus comma separated signers seal blocks in turns, they are committed to the genesis block, so every node of the chain has to be created with the same signers")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
-	fmt.Println(" createwallet - Creates a new Wallet")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -fee FEE -mine -threads N -rbf - Send amount of coins paying fee to miner. Then -mine flag is set, mine off of this node on N threads. With -rbf flag sending again with higher fee replaces the transaction in memory pools")
+	fmt.Println(" createwallet -type p256|ed25519 - Creates a new Wallet with key of type")
+	fmt.Println(" getpubkey -address ADDRESS - Prints public key of address from our wallet file")
+	fmt.Println(" createmultisig -m M -pubkeys PUBKEYS - Creates address requiring M signatures of comma separated hex public keys")
+	fmt.Println(" createtimelock -address ADDRESS -locktime LOCKTIME | -blocks N - Creates address which funds ADDRESS can spend from after block height or unix time LOCKTIME, or N blocks after they are received. Spend them with send")
+	fmt.Println(" createmultisigtx -from MULTISIG -to TO -amount AMOUNT -fee FEE - Prints unsigned transaction spending from multisig address")
+	fmt.Println(" cosign -tx TX -from MULTISIG -signer ADDRESS - Adds signature of ADDRESS to multisig transaction and prints it")
+	fmt.Println(" sendrawtx -tx TX -mine -miner ADDRESS - Sends signed transaction. Then -mine flag is set, mine off of this node paying reward to ADDRESS")
+	fmt.Println(" initiateswap -from FROM -participant ADDRESS -amount AMOUNT -fee FEE -locktime LOCKTIME -mine - Starts atomic swap locking amount in contract the participant redeems with new secret, FROM can refund it after block height or unix time LOCKTIME")
+	fmt.Println(" participateswap -from FROM -initiator ADDRESS -hash HASH -amount AMOUNT -fee FEE -locktime LOCKTIME -mine - Joins atomic swap locking amount in contract the initiator redeems with secret of HASH, LOCKTIME has to be earlier than initiator one")
+	fmt.Println(" auditswap -contract CONTRACT - Prints terms and locked amount of swap contract")
+	fmt.Println(" redeemswap -contract CONTRACT -secret SECRET -to TO -fee FEE -mine - Sends funds of swap contract to TO revealing the secret")
+	fmt.Println(" refundswap -contract CONTRACT -to TO -fee FEE -mine - Sends funds of swap contract back to TO after its lock time")
+	fmt.Println(" extractsecret -contract CONTRACT - Prints secret revealed by redeemed swap contract")
+	fmt.Println(" mine -address ADDRESS -blocks N -threads N - Mines N blocks without transactions paying reward to ADDRESS on N threads, so earlier rewards mature")
+	fmt.Println(" generate -blocks N -address ADDRESS - Mines N blocks paying to ADDRESS instantly and prints their hashes, regtest only")
+	fmt.Println(" notarize -from FROM -hash HASH -fee FEE -mine - Records hex HASH of at most 80 bytes in the chain paying fee from FROM")
+	fmt.Println(" findnotarization -hash HASH - Prints block and merkle proof of transaction recording HASH")
+	fmt.Println(" listunspent -address ADDRESS - Lists spendable outputs of address as TXID:OUT VALUE")
+	fmt.Println(" createrawtx -tx TX -inputs TXID:OUT,... -outputs ADDRESS:AMOUNT,... - Prints unsigned transaction, or TX with inputs and outputs added")
+	fmt.Println(" signrawtx -tx TX -signer ADDRESS -sighash ALL|NONE|SINGLE[|ANYONECANPAY] - Signs inputs of ADDRESS, with ANYONECANPAY others can still add inputs")
 	fmt.Println(" listaddresses - List the addresses in our wallet file")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
-	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ENV env. var. -miner enables mining")
+	fmt.Println(" supply - Reports issued and maximum supply of tokens")
+	fmt.Println(" startnode -miner ADDRESS -threads N - Start a node with ID specified in NODE_ENV env. var. -miner enables mining on N threads")
 }
 
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CommandLine) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		runtime.Goexit()
 	}
@@ -56,13 +85,36 @@ func (cli *CommandLine) StartNode(nodeId, minerAddress string) {
 func (cli *CommandLine) reindexUTXO(nodeId string) {
 	chain := blockchain.ContinueBlockChain(nodeId)
 	defer chain.Database.Close()
-	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	UTXOSet.Reindex()
 
 	count := UTXOSet.CountTransactions()
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
+func (cli *CommandLine) supply(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	height := chain.GetBestHeight()
+	premined := chain.GenesisSupply()
+
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Block subsidy: %d\n", blockchain.Subsidy(height))
+	fmt.Printf("Network: %s\n", chaincfg.Active.Name)
+	fmt.Printf("Halving interval: %d blocks\n", chaincfg.Active.HalvingInterval)
+	fmt.Printf("Premined supply: %d\n", premined)
+	fmt.Printf("Issued supply: %d\n", premined+blockchain.ScheduledSupply(height))
+	fmt.Printf("Circulating supply: %d\n", UTXOSet.TotalValue())
+
+	if maxSupply := blockchain.MaxSupply(); maxSupply < 0 {
+		fmt.Println("Maximum supply: unlimited")
+	} else {
+		fmt.Printf("Maximum supply: %d\n", premined+maxSupply)
+	}
+}
+
 func (cli *CommandLine) printChain(nodeId string) {
 	chain := blockchain.ContinueBlockChain(nodeId)
 	defer chain.Database.Close()
@@ -75,84 +127,712 @@ func (cli *CommandLine) printChain(nodeId string) {
 		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
 
-		pow := blockchain.NewProof(block)
+		fmt.Printf("Seal: %s\n", strconv.FormatBool(chain.Engine.VerifySeal(block) == nil))
+
+		for _, tx := range block.Transactions {
+			fmt.Println(tx)
+		}
+
+		fmt.Println()
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+}
+
+func (cli *CommandLine) createBlockChain(genesisFile, consensus, signers string, nodeId string) {
+	var genesis *chaincfg.Genesis
+
+	if genesisFile != "" {
+		var err error
+
+		genesis, err = chaincfg.LoadGenesis(genesisFile)
+		util.HandleError(err)
+	}
+
+	config := blockchain.ConsensusConfig{Engine: consensus}
+
+	if consensus == blockchain.PoAEngine {
+		for _, signer := range strings.Split(signers, ",") {
+			if !wallet.ValidateAddress(signer) {
+				log.Panic("Signer address is not Valid")
+			}
+
+			pubKeyHash := util.Base58Decode([]byte(signer))
+			config.Signers = append(config.Signers, pubKeyHash[1:len(pubKeyHash)-4])
+		}
+	}
+
+	chain := blockchain.InitBlockChain(nodeId, config, genesis)
+	fmt.Printf("Genesis block: %x\n", chain.GenesisHash())
+	fmt.Printf("Premined supply: %d\n", chain.GenesisSupply())
+	chain.Database.Close()
+	fmt.Println("Finished")
+}
+
+func (cli *CommandLine) getBalance(address, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	balance, immature := UTXOSet.FindBalance(blockchain.LockScript(address))
+
+	fmt.Printf("Balance of %s: %d\n", address, balance)
+	fmt.Printf("Immature balance of %s: %d\n", address, immature)
+}
+
+func (cli *CommandLine) send(from, to string, amount, fee int, nodeId string, mineNow, replaceable bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is not Valid")
+	}
+	if !wallet.ValidateAddress(to) {
+		log.Panic("Address is not Valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	var sender wallet.Wallet
+	var tx *blockchain.Transaction
+
+	if script, ok := wallets.GetScript(from); ok {
+		// timelocked funds are spent with the key of the script owner
+		_, _, pubKeyHash, isTimelock := blockchain.ParseTimelockScript(script)
+
+		if !isTimelock {
+			log.Panic("Only timelock addresses can be spent with send, use createmultisigtx for multisig")
+		}
+
+		owner, ok := wallets.GetKeyAddress(pubKeyHash)
+
+		if !ok {
+			log.Panic("Owner of timelock address is not in the wallet file")
+		}
+
+		sender = wallets.GetWallet(owner)
+		tx, err = blockchain.NewTimelockTransaction(&sender, script, to, amount, fee, &UTXOSet)
+		util.HandleError(err)
+	} else if replaceable {
+		sender = wallets.GetWallet(from)
+		tx, err = blockchain.NewReplaceableTransaction(&sender, to, amount, fee, &UTXOSet)
+		util.HandleError(err)
+	} else {
+		sender = wallets.GetWallet(from)
+		tx, err = blockchain.NewTransaction(&sender, to, amount, fee, &UTXOSet)
+		util.HandleError(err)
+	}
+
+	if mineNow {
+		// with proof-of-authority the sender seals the block and has to be in turn
+		if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok {
+			poa.Signer = &sender
+		}
+
+		cbTx := blockchain.CoinbaseTx(from, "", chain.GetBestHeight()+1, fee)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		_, err := chain.MineBlock(context.Background(), txs)
+		util.HandleError(err)
+	} else {
+		network.SendTx(network.KnownNodes[0], tx)
+		fmt.Println("send tx")
+	}
+
+	fmt.Println("Success!")
+}
+
+func (cli *CommandLine) getPubKey(address, nodeId string) {
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	if _, ok := wallets.Wallets[address]; !ok {
+		log.Panic("Address is not in the wallet file")
+	}
+
+	fmt.Printf("%x\n", wallets.GetWallet(address).PublicKey)
+}
+
+func (cli *CommandLine) createMultisig(m int, pubKeys string, nodeId string) {
+	var keys [][]byte
+
+	for _, pubKey := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(pubKey)
+		util.HandleError(err)
+
+		keys = append(keys, key)
+	}
+
+	script, err := blockchain.MultisigScript(m, keys)
+	util.HandleError(err)
+
+	wallets, _ := wallet.CreateWallets(nodeId)
+	address := wallets.AddScript(script)
+	wallets.SaveFile(nodeId)
+
+	fmt.Printf("New multisig address is: %s\n", address)
+	fmt.Printf("Script: %s\n", blockchain.DisasmScript(script))
+}
+
+func (cli *CommandLine) createTimelock(address string, lockTime, blocks int64, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+
+	version, pubKeyHash := wallet.DecodeAddress(address)
+
+	if !wallet.IsKeyAddressVersion(version) {
+		log.Panic("Timelock can be created for key address only")
+	}
+
+	var script []byte
+
+	if lockTime > 0 {
+		script = blockchain.TimelockScript(blockchain.OP_CHECKLOCKTIMEVERIFY, lockTime, pubKeyHash)
+	} else {
+		script = blockchain.TimelockScript(blockchain.OP_CHECKSEQUENCEVERIFY, blocks, pubKeyHash)
+	}
+
+	wallets, _ := wallet.CreateWallets(nodeId)
+	timelockAddress := wallets.AddScript(script)
+	wallets.SaveFile(nodeId)
+
+	fmt.Printf("New timelock address is: %s\n", timelockAddress)
+	fmt.Printf("Script: %s\n", blockchain.DisasmScript(script))
+}
+
+func (cli *CommandLine) createMultisigTx(from, to string, amount, fee int, nodeId string) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is not Valid")
+	}
+	if !wallet.ValidateAddress(to) {
+		log.Panic("Address is not Valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	tx, err := blockchain.NewMultisigTransaction(from, to, amount, fee, &UTXOSet)
+	util.HandleError(err)
+
+	fmt.Printf("%x\n", tx.Serialize())
+}
+
+func (cli *CommandLine) cosign(rawTx, from, signer, nodeId string) {
+	data, err := hex.DecodeString(rawTx)
+	util.HandleError(err)
+
+	tx, err := blockchain.DecodeTransaction(data)
+	util.HandleError(err)
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	script, ok := wallets.GetScript(from)
+
+	if !ok {
+		log.Panic("Multisig address is not in the wallet file, create it with createmultisig")
+	}
+
+	if _, ok := wallets.Wallets[signer]; !ok {
+		log.Panic("Signer address is not in the wallet file")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	w := wallets.GetWallet(signer)
+	complete, err := chain.SignMultisigTransaction(&tx, &w, script)
+	util.HandleError(err)
+
+	fmt.Printf("%x\n", tx.Serialize())
+
+	if complete {
+		fmt.Println("Transaction is fully signed, send it with sendrawtx")
+	} else {
+		fmt.Println("More signatures are required")
+	}
+}
+
+func (cli *CommandLine) sendRawTx(rawTx string, nodeId string, mineNow bool, miner string) {
+	data, err := hex.DecodeString(rawTx)
+	util.HandleError(err)
+
+	tx, err := blockchain.DecodeTransaction(data)
+	util.HandleError(err)
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	if !chain.VerifyTransaction(&tx) {
+		log.Panic("Transaction is not signed")
+	}
+
+	if mineNow {
+		if !wallet.ValidateAddress(miner) {
+			log.Panic("Miner address is not Valid")
+		}
+
+		prevOuts, err := UTXOSet.FindOutputs(&tx)
+		util.HandleError(err)
+
+		if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok {
+			wallets, err := wallet.CreateWallets(nodeId)
+			util.HandleError(err)
+
+			signer := wallets.GetWallet(miner)
+			poa.Signer = &signer
+		}
+
+		fee, err := blockchain.Fee(&tx, prevOuts)
+		util.HandleError(err)
+
+		cbTx := blockchain.CoinbaseTx(miner, "", chain.GetBestHeight()+1, fee)
+		txs := []*blockchain.Transaction{cbTx, &tx}
+		_, err = chain.MineBlock(context.Background(), txs)
+		util.HandleError(err)
+	} else {
+		network.SendTx(network.KnownNodes[0], &tx)
+		fmt.Println("send tx")
+	}
+
+	fmt.Println("Success!")
+}
+
+// submitTx mines tx in a block paying reward to miner when mineNow is set, otherwise sends it to the known node
+func (cli *CommandLine) submitTx(chain *blockchain.Chain, tx *blockchain.Transaction, miner *wallet.Wallet, mineNow bool) {
+	if mineNow {
+		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+		prevOuts, err := UTXOSet.FindOutputs(tx)
+		util.HandleError(err)
+
+		if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok {
+			poa.Signer = miner
+		}
+
+		fee, err := blockchain.Fee(tx, prevOuts)
+		util.HandleError(err)
+
+		cbTx := blockchain.CoinbaseTx(string(miner.Address()), "", chain.GetBestHeight()+1, fee)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		_, err = chain.MineBlock(context.Background(), txs)
+		util.HandleError(err)
+	} else {
+		network.SendTx(network.KnownNodes[0], tx)
+		fmt.Println("send tx")
+	}
+}
+
+// lockSwap locks amount of from in contract paying to recipient for the secret
+// with secretHash, from can take it back after lockTime
+func (cli *CommandLine) lockSwap(from, recipient string, secretHash []byte, lockTime int64, amount, fee int, nodeId string, mineNow bool) []byte {
+	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(recipient) {
+		log.Panic("Address is not Valid")
+	}
+
+	refundVersion, refund := wallet.DecodeAddress(from)
+	recipientVersion, recipientHash := wallet.DecodeAddress(recipient)
+
+	if !wallet.IsKeyAddressVersion(refundVersion) || !wallet.IsKeyAddressVersion(recipientVersion) {
+		log.Panic("Swap can be made between key addresses only")
+	}
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	if _, ok := wallets.Wallets[from]; !ok {
+		log.Panic("Address is not in the wallet file")
+	}
+
+	contract := blockchain.HTLCScript(secretHash, recipientHash, refund, lockTime)
+	contractAddress := wallets.AddScript(contract)
+	wallets.SaveFile(nodeId)
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	sender := wallets.GetWallet(from)
+	tx, err := blockchain.NewTransaction(&sender, contractAddress, amount, fee, &UTXOSet)
+	util.HandleError(err)
+	cli.submitTx(chain, tx, &sender, mineNow)
+
+	fmt.Printf("Contract address: %s\n", contractAddress)
+	fmt.Printf("Contract: %x\n", contract)
+	fmt.Printf("Contract transaction: %x\n", tx.ID)
+
+	return contract
+}
+
+func (cli *CommandLine) initiateSwap(from, participant string, lockTime int64, amount, fee int, nodeId string, mineNow bool) {
+	secret := make([]byte, blockchain.SecretSize)
+	_, err := rand.Read(secret)
+	util.HandleError(err)
+
+	secretHash := sha256.Sum256(secret)
+
+	cli.lockSwap(from, participant, secretHash[:], lockTime, amount, fee, nodeId, mineNow)
+
+	fmt.Printf("Secret: %x\n", secret)
+	fmt.Printf("Secret hash: %x\n", secretHash)
+	fmt.Println("Keep the secret until the participant locks coins on the other chain")
+}
+
+func (cli *CommandLine) participateSwap(from, initiator, secretHash string, lockTime int64, amount, fee int, nodeId string, mineNow bool) {
+	hash, err := hex.DecodeString(secretHash)
+	util.HandleError(err)
+
+	if len(hash) != sha256.Size {
+		log.Panic("Secret hash is not Valid")
+	}
+
+	cli.lockSwap(from, initiator, hash, lockTime, amount, fee, nodeId, mineNow)
+}
+
+func decodeContract(contract string) ([]byte, []byte, []byte, []byte, int64) {
+	script, err := hex.DecodeString(contract)
+	util.HandleError(err)
+
+	secretHash, recipient, refund, lockTime, ok := blockchain.ParseHTLCScript(script)
+
+	if !ok {
+		log.Panic("Contract is not a hash time-locked contract")
+	}
+
+	return script, secretHash, recipient, refund, lockTime
+}
+
+func (cli *CommandLine) auditSwap(contract, nodeId string) {
+	script, secretHash, recipient, refund, lockTime := decodeContract(contract)
+	address := fmt.Sprintf("%s", wallet.ScriptAddress(script))
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	balance, _ := UTXOSet.FindBalance(blockchain.LockScript(address))
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	fmt.Printf("Contract address: %s\n", address)
+	fmt.Printf("Locked amount: %d\n", balance)
+	fmt.Printf("Recipient: %s\n", keyAddress(wallets, chain, recipient))
+	fmt.Printf("Refund address: %s\n", keyAddress(wallets, chain, refund))
+	fmt.Printf("Secret hash: %x\n", secretHash)
+
+	if lockTime < blockchain.LockTimeThreshold {
+		fmt.Printf("Refundable from block: %d (current height %d)\n", lockTime, chain.GetBestHeight())
+	} else {
+		fmt.Printf("Refundable after: %s\n", time.Unix(lockTime, 0))
+	}
+}
+
+// keyAddress returns address of key with hash pubKeyHash. Address version depends on key type,
+// which is known for keys of the wallet file and keys revealed on the chain. Other keys
+// are taken as P256 keys
+func keyAddress(wallets *wallet.Wallets, chain *blockchain.Chain, pubKeyHash []byte) string {
+	if address, ok := wallets.GetKeyAddress(pubKeyHash); ok {
+		return address
+	}
+
+	keyType := wallet.P256
+
+	if pubKey, ok := chain.FindPublicKey(pubKeyHash); ok {
+		keyType, _ = wallet.PublicKeyType(pubKey)
+	}
+
+	return fmt.Sprintf("%s", wallet.EncodeAddress(keyType.AddressVersion(), pubKeyHash))
+}
+
+func (cli *CommandLine) redeemSwap(contract, secret, to string, fee int, nodeId string, mineNow bool) {
+	script, _, recipient, _, _ := decodeContract(contract)
+
+	preimage, err := hex.DecodeString(secret)
+	util.HandleError(err)
+
+	cli.spendSwap(recipient, to, nodeId, mineNow, func(w *wallet.Wallet, UTXOSet *blockchain.UTXOSet) (*blockchain.Transaction, error) {
+		return blockchain.NewHTLCRedeemTransaction(w, script, preimage, to, fee, UTXOSet)
+	})
+}
+
+func (cli *CommandLine) refundSwap(contract, to string, fee int, nodeId string, mineNow bool) {
+	script, _, _, refund, _ := decodeContract(contract)
+
+	cli.spendSwap(refund, to, nodeId, mineNow, func(w *wallet.Wallet, UTXOSet *blockchain.UTXOSet) (*blockchain.Transaction, error) {
+		return blockchain.NewHTLCRefundTransaction(w, script, to, fee, UTXOSet)
+	})
+}
+
+// spendSwap creates transaction spending contract with key of pubKeyHash from the wallet file
+func (cli *CommandLine) spendSwap(pubKeyHash []byte, to, nodeId string, mineNow bool, spend func(*wallet.Wallet, *blockchain.UTXOSet) (*blockchain.Transaction, error)) {
+	if !wallet.ValidateAddress(to) {
+		log.Panic("Address is not Valid")
+	}
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	owner, ok := wallets.GetKeyAddress(pubKeyHash)
+
+	if !ok {
+		log.Panic("Key spending the contract is not in the wallet file")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	sender := wallets.GetWallet(owner)
+	tx, err := spend(&sender, &UTXOSet)
+	util.HandleError(err)
+
+	if err := chain.CheckLockTimes(tx); err != nil {
+		log.Panic(err)
+	}
+
+	cli.submitTx(chain, tx, &sender, mineNow)
+
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Println("Success!")
+}
+
+func (cli *CommandLine) extractSecret(contract, nodeId string) {
+	script, _, _, _, _ := decodeContract(contract)
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	secret, err := chain.FindHTLCSecret(script)
+	util.HandleError(err)
+
+	fmt.Printf("Secret: %x\n", secret)
+}
+
+// mine mines blocks holding coinbase only off this node, they bury rewards of earlier blocks
+// under coinbase maturity blocks of the network, so the rewards can be spent
+func (cli *CommandLine) mine(blocks int, address, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	// with proof-of-authority the miner seals blocks and has to be in turn
+	if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok {
+		wallets, err := wallet.CreateWallets(nodeId)
+		util.HandleError(err)
 
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		miner := wallets.GetWallet(address)
+		poa.Signer = &miner
+	}
 
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
+	for i := 0; i < blocks; i++ {
+		cbTx := blockchain.CoinbaseTx(address, "", chain.GetBestHeight()+1, 0)
+		block, err := chain.MineBlock(context.Background(), []*blockchain.Transaction{cbTx})
+		util.HandleError(err)
 
-		fmt.Println()
+		fmt.Printf("%x\n", block.Hash)
 
-		if len(block.PrevHash) == 0 {
-			break
+		if pow, ok := chain.Engine.(*blockchain.ProofOfWorkEngine); ok {
+			fmt.Printf("Hash rate: %.0f H/s with %d threads\n", pow.HashRate(), blockchain.MinerThreads)
 		}
 	}
 }
 
-func (cli *CommandLine) createBlockChain(address string, nodeId string) {
+// generate mines blocks paying to address instantly, it is only allowed on regtest
+func (cli *CommandLine) generate(blocks int, address, nodeId string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
 
-	chain := blockchain.InitBlockChain(address, nodeId)
-	chain.Database.Close()
-	fmt.Println("Finished")
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	generated, err := chain.Generate(context.Background(), blocks, address)
+
+	for _, block := range generated {
+		fmt.Printf("%x\n", block.Hash)
+	}
+
+	util.HandleError(err)
 }
 
-func (cli *CommandLine) getBalance(address, nodeId string) {
-	if !wallet.ValidateAddress(address) {
+func (cli *CommandLine) notarize(from, hash string, fee int, nodeId string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
 		log.Panic("Address is not Valid")
 	}
 
+	data, err := hex.DecodeString(hash)
+	util.HandleError(err)
+
+	wallets, err := wallet.CreateWallets(nodeId)
+	util.HandleError(err)
+
+	if _, ok := wallets.Wallets[from]; !ok {
+		log.Panic("Address is not in the wallet file")
+	}
+
 	chain := blockchain.ContinueBlockChain(nodeId)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
-	UTXOSet := blockchain.UTXOSet{chain}
+	sender := wallets.GetWallet(from)
+	tx, err := blockchain.NewDataTransaction(&sender, data, fee, &UTXOSet)
+	util.HandleError(err)
+
+	cli.submitTx(chain, tx, &sender, mineNow)
+
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Println("Success!")
+}
+
+func (cli *CommandLine) findNotarization(hash, nodeId string) {
+	data, err := hex.DecodeString(hash)
+	util.HandleError(err)
+
+	chain := blockchain.ContinueBlockChain(nodeId)
 	defer chain.Database.Close()
 
-	balance := 0
-	pubKeyHash := util.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	block, tx, proof, err := chain.FindDataProof(data)
+	util.HandleError(err)
+
+	fmt.Printf("Block: %x\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Time: %s\n", time.Unix(block.Timestamp, 0))
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Println("Merkle proof:")
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	for _, step := range proof {
+		if step.Left {
+			fmt.Printf("  left  %x\n", step.Hash)
+		} else {
+			fmt.Printf("  right %x\n", step.Hash)
+		}
 	}
 
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+	fmt.Printf("Proof valid: %s\n", strconv.FormatBool(blockchain.VerifyProof(block.MerkleRoot, tx.ID, proof)))
+	fmt.Printf("Confirmations: %d\n", chain.GetBestHeight()-block.Height+1)
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool) {
-	if !wallet.ValidateAddress(from) {
-		log.Panic("Address is not Valid")
-	}
-	if !wallet.ValidateAddress(to) {
+func (cli *CommandLine) listUnspent(address, nodeId string) {
+	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
 
 	chain := blockchain.ContinueBlockChain(nodeId)
-	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
+	_, unspent := UTXOSet.FindSpendableOutputs(blockchain.LockScript(address), math.MaxInt)
+
+	for txID, outs := range unspent {
+		id, err := hex.DecodeString(txID)
+		util.HandleError(err)
+
+		for _, out := range outs {
+			spend := blockchain.Transaction{Inputs: []blockchain.TxInput{{ID: id, Out: out}}}
+			prevOuts, err := UTXOSet.FindOutputs(&spend)
+			util.HandleError(err)
+
+			fmt.Printf("%s:%d %d\n", txID, out, prevOuts[0].Value)
+		}
+	}
+}
+
+// createRawTx adds inputs and outputs to transaction rawTx, which is empty if not given
+func (cli *CommandLine) createRawTx(rawTx, inputs, outputs string) {
+	var tx blockchain.Transaction
+
+	if rawTx != "" {
+		data, err := hex.DecodeString(rawTx)
+		util.HandleError(err)
+
+		tx, err = blockchain.DecodeTransaction(data)
+		util.HandleError(err)
+	}
+
+	if inputs != "" {
+		for _, input := range strings.Split(inputs, ",") {
+			parts := strings.Split(input, ":")
+
+			if len(parts) != 2 {
+				log.Panic("Input has to be TXID:OUT")
+			}
+
+			txID, err := hex.DecodeString(parts[0])
+			util.HandleError(err)
+
+			out, err := strconv.Atoi(parts[1])
+			util.HandleError(err)
+
+			tx.Inputs = append(tx.Inputs, blockchain.TxInput{ID: txID, Out: out, Sequence: blockchain.MaxSequence})
+		}
+	}
+
+	if outputs != "" {
+		for _, output := range strings.Split(outputs, ",") {
+			parts := strings.Split(output, ":")
+
+			if len(parts) != 2 || !wallet.ValidateAddress(parts[0]) {
+				log.Panic("Output has to be ADDRESS:AMOUNT")
+			}
+
+			amount, err := strconv.Atoi(parts[1])
+			util.HandleError(err)
+
+			tx.Outputs = append(tx.Outputs, *blockchain.NewTXOutput(amount, parts[0]))
+		}
+	}
+
+	fmt.Printf("%x\n", tx.Serialize())
+}
+
+func (cli *CommandLine) signRawTx(rawTx, signer, sigHash, nodeId string) {
+	data, err := hex.DecodeString(rawTx)
+	util.HandleError(err)
+
+	tx, err := blockchain.DecodeTransaction(data)
+	util.HandleError(err)
+
+	hashType, err := blockchain.ParseSigHashType(sigHash)
+	util.HandleError(err)
+
 	wallets, err := wallet.CreateWallets(nodeId)
 	util.HandleError(err)
 
-	wallet := wallets.GetWallet(from)
-	tx := blockchain.NewTransaction(&wallet, to, amount, &UTXOSet)
+	if _, ok := wallets.Wallets[signer]; !ok {
+		log.Panic("Signer address is not in the wallet file")
+	}
 
-	if mineNow {
-		cbTx := blockchain.CoinbaseTx(from, "")
-		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	w := wallets.GetWallet(signer)
+	err = chain.SignTransactionWithHashType(&tx, &w, hashType)
+	util.HandleError(err)
+
+	fmt.Printf("%x\n", tx.Serialize())
+
+	if chain.VerifyTransaction(&tx) {
+		fmt.Println("Transaction is fully signed, send it with sendrawtx")
 	} else {
-		network.SendTx(network.KnownNodes[0], tx)
-		fmt.Println("send tx")
+		fmt.Println("More signatures are required")
 	}
-
-	fmt.Println("Success!")
 }
 
 func (cli *CommandLine) listAddresses(nodeId string) {
@@ -164,22 +844,40 @@ func (cli *CommandLine) listAddresses(nodeId string) {
 	}
 }
 
-func (cli *CommandLine) createWallet(nodeId string) {
+func (cli *CommandLine) createWallet(keyType, nodeId string) {
+	t, err := wallet.ParseKeyType(keyType)
+	util.HandleError(err)
+
 	wallets, _ := wallet.CreateWallets(nodeId)
-	address := wallets.AddWallet()
+	address := wallets.AddWallet(t)
 	wallets.SaveFile(nodeId)
 
 	fmt.Printf("New address is: %s\n", address)
 }
 
 func (cli *CommandLine) Run() {
-	cli.validateArgs()
+	// network is chosen before the command, so every command runs on the same network
+	networkCmd := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	networkName := networkCmd.String("network", chaincfg.MainNetParams.Name, "Network to use: mainnet, testnet or regtest")
+	networkCmd.Usage = cli.printUsage
+
+	err := networkCmd.Parse(os.Args[1:])
+	if err != nil {
+		log.Panic(err)
+	}
+
+	args := networkCmd.Args()
+	cli.validateArgs(args)
+
+	params, err := chaincfg.Select(*networkName)
+	util.HandleError(err)
+
+	network.KnownNodes = append([]string{}, params.Seeds...)
 
 	nodeId := os.Getenv("NODE_ID")
 
 	if nodeId == "" {
-		fmt.Printf("NODE_ID env is not set!")
-		runtime.Goexit()
+		nodeId = params.DefaultPort
 	}
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
@@ -189,54 +887,238 @@ func (cli *CommandLine) Run() {
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	createTimelockCmd := flag.NewFlagSet("createtimelock", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultisigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	cosignCmd := flag.NewFlagSet("cosign", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
+	initiateSwapCmd := flag.NewFlagSet("initiateswap", flag.ExitOnError)
+	participateSwapCmd := flag.NewFlagSet("participateswap", flag.ExitOnError)
+	auditSwapCmd := flag.NewFlagSet("auditswap", flag.ExitOnError)
+	redeemSwapCmd := flag.NewFlagSet("redeemswap", flag.ExitOnError)
+	refundSwapCmd := flag.NewFlagSet("refundswap", flag.ExitOnError)
+	extractSecretCmd := flag.NewFlagSet("extractsecret", flag.ExitOnError)
+	notarizeCmd := flag.NewFlagSet("notarize", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	findNotarizationCmd := flag.NewFlagSet("findnotarization", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createWalletType := createWalletCmd.String("type", "p256", "Key type, p256 or ed25519")
+	createBlockchainGenesis := createBlockchainCmd.String("genesis", "", "Genesis JSON file with premine allocations of a private network")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", blockchain.PoWEngine, "Consensus engine, pow or poa")
+	createBlockchainSigners := createBlockchainCmd.String("signers", "", "Comma separated addresses sealing blocks with poa consensus")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendThreads := sendCmd.Int("threads", runtime.NumCPU(), "Number of mining threads")
+	sendRBF := sendCmd.Bool("rbf", false, "Allow replacing transaction with one paying higher fee until it is mined")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeThreads := startNodeCmd.Int("threads", runtime.NumCPU(), "Number of mining threads")
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "The address to print public key of")
+	createTimelockAddress := createTimelockCmd.String("address", "", "The address which can spend funds once the lock is over")
+	createTimelockLockTime := createTimelockCmd.Int64("locktime", 0, "Block height or unix time funds are locked until")
+	createTimelockBlocks := createTimelockCmd.Int64("blocks", 0, "Number of blocks funds are locked for after they are received")
+	createMultisigM := createMultisigCmd.Int("m", 0, "Number of required signatures")
+	createMultisigPubKeys := createMultisigCmd.String("pubkeys", "", "Comma separated hex public keys")
+	createMultisigTxFrom := createMultisigTxCmd.String("from", "", "Source multisig address")
+	createMultisigTxTo := createMultisigTxCmd.String("to", "", "Destination wallet address")
+	createMultisigTxAmount := createMultisigTxCmd.Int("amount", 0, "Amount to send")
+	createMultisigTxFee := createMultisigTxCmd.Int("fee", 0, "Fee paid to miner")
+	cosignTx := cosignCmd.String("tx", "", "Hex encoded transaction")
+	cosignFrom := cosignCmd.String("from", "", "Multisig address transaction spends from")
+	cosignSigner := cosignCmd.String("signer", "", "Wallet address signing transaction")
+	sendRawTxTx := sendRawTxCmd.String("tx", "", "Hex encoded signed transaction")
+	sendRawTxMine := sendRawTxCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendRawTxMiner := sendRawTxCmd.String("miner", "", "Address to receive mining reward")
+	initiateSwapFrom := initiateSwapCmd.String("from", "", "Source wallet address, which can refund the contract")
+	initiateSwapParticipant := initiateSwapCmd.String("participant", "", "Address of participant on this chain")
+	initiateSwapAmount := initiateSwapCmd.Int("amount", 0, "Amount to lock")
+	initiateSwapFee := initiateSwapCmd.Int("fee", 0, "Fee paid to miner")
+	initiateSwapLockTime := initiateSwapCmd.Int64("locktime", 0, "Block height or unix time after which the contract can be refunded")
+	initiateSwapMine := initiateSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	participateSwapFrom := participateSwapCmd.String("from", "", "Source wallet address, which can refund the contract")
+	participateSwapInitiator := participateSwapCmd.String("initiator", "", "Address of initiator on this chain")
+	participateSwapHash := participateSwapCmd.String("hash", "", "Hex secret hash of initiator contract")
+	participateSwapAmount := participateSwapCmd.Int("amount", 0, "Amount to lock")
+	participateSwapFee := participateSwapCmd.Int("fee", 0, "Fee paid to miner")
+	participateSwapLockTime := participateSwapCmd.Int64("locktime", 0, "Block height or unix time after which the contract can be refunded")
+	participateSwapMine := participateSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	auditSwapContract := auditSwapCmd.String("contract", "", "Hex encoded swap contract")
+	redeemSwapContract := redeemSwapCmd.String("contract", "", "Hex encoded swap contract")
+	redeemSwapSecret := redeemSwapCmd.String("secret", "", "Hex encoded secret")
+	redeemSwapTo := redeemSwapCmd.String("to", "", "Destination wallet address")
+	redeemSwapFee := redeemSwapCmd.Int("fee", 0, "Fee paid to miner")
+	redeemSwapMine := redeemSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	refundSwapContract := refundSwapCmd.String("contract", "", "Hex encoded swap contract")
+	refundSwapTo := refundSwapCmd.String("to", "", "Destination wallet address")
+	refundSwapFee := refundSwapCmd.Int("fee", 0, "Fee paid to miner")
+	refundSwapMine := refundSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	extractSecretContract := extractSecretCmd.String("contract", "", "Hex encoded swap contract")
+	notarizeFrom := notarizeCmd.String("from", "", "Wallet address paying the fee")
+	notarizeHash := notarizeCmd.String("hash", "", "Hex encoded hash to record")
+	notarizeFee := notarizeCmd.Int("fee", 1, "Fee paid to miner")
+	notarizeMine := notarizeCmd.Bool("mine", false, "Mine immediately on the same node")
+	mineAddress := mineCmd.String("address", "", "The address to send block rewards to")
+	mineBlocks := mineCmd.Int("blocks", 1, "Number of blocks to mine")
+	mineThreads := mineCmd.Int("threads", runtime.NumCPU(), "Number of mining threads")
+	generateBlocks := generateCmd.Int("blocks", 1, "Number of blocks to mine")
+	generateAddress := generateCmd.String("address", "", "The address to send block rewards to")
+	findNotarizationHash := findNotarizationCmd.String("hash", "", "Hex encoded recorded hash")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list outputs of")
+	createRawTxTx := createRawTxCmd.String("tx", "", "Hex encoded transaction to extend")
+	createRawTxInputs := createRawTxCmd.String("inputs", "", "Comma separated TXID:OUT outputs to spend")
+	createRawTxOutputs := createRawTxCmd.String("outputs", "", "Comma separated ADDRESS:AMOUNT outputs to create")
+	signRawTxTx := signRawTxCmd.String("tx", "", "Hex encoded transaction")
+	signRawTxSigner := signRawTxCmd.String("signer", "", "Wallet address signing its inputs")
+	signRawTxSigHash := signRawTxCmd.String("sighash", "ALL", "Signature hash type")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "reindexutxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplyCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
+		err := startNodeCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createtimelock":
+		err := createTimelockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisigtx":
+		err := createMultisigTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "cosign":
+		err := cosignCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "initiateswap":
+		err := initiateSwapCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "participateswap":
+		err := participateSwapCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "auditswap":
+		err := auditSwapCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "redeemswap":
+		err := redeemSwapCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "refundswap":
+		err := refundSwapCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "extractsecret":
+		err := extractSecretCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mine":
+		err := mineCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "notarize":
+		err := notarizeCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "findnotarization":
+		err := findNotarizationCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listunspent":
+		err := listUnspentCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -255,11 +1137,12 @@ func (cli *CommandLine) Run() {
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainConsensus == blockchain.PoAEngine && *createBlockchainSigners == "" {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.createBlockChain(*createBlockchainAddress, nodeId)
+
+		cli.createBlockChain(*createBlockchainGenesis, *createBlockchainConsensus, *createBlockchainSigners, nodeId)
 	}
 
 	if printChainCmd.Parsed() {
@@ -267,16 +1150,17 @@ func (cli *CommandLine) Run() {
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeId, *sendMine)
+		blockchain.MinerThreads = *sendThreads
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, nodeId, *sendMine, *sendRBF)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeId)
+		cli.createWallet(*createWalletType, nodeId)
 	}
 
 	if listAddressesCmd.Parsed() {
@@ -287,14 +1171,191 @@ func (cli *CommandLine) Run() {
 		cli.reindexUTXO(nodeId)
 	}
 
-	if startNodeCmd.Parsed() {
-		nodeId := os.Getenv("NODE_ID")
+	if supplyCmd.Parsed() {
+		cli.supply(nodeId)
+	}
+
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.getPubKey(*getPubKeyAddress, nodeId)
+	}
+
+	if createTimelockCmd.Parsed() {
+		validLock := (*createTimelockLockTime > 0) != (*createTimelockBlocks > 0) &&
+			*createTimelockLockTime <= int64(blockchain.MaxSequence) &&
+			*createTimelockBlocks <= int64(blockchain.SequenceLockMask)
+
+		if *createTimelockAddress == "" || !validLock {
+			createTimelockCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.createTimelock(*createTimelockAddress, *createTimelockLockTime, *createTimelockBlocks, nodeId)
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigM <= 0 || *createMultisigPubKeys == "" {
+			createMultisigCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.createMultisig(*createMultisigM, *createMultisigPubKeys, nodeId)
+	}
+
+	if createMultisigTxCmd.Parsed() {
+		if *createMultisigTxFrom == "" || *createMultisigTxTo == "" || *createMultisigTxAmount <= 0 || *createMultisigTxFee < 0 {
+			createMultisigTxCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.createMultisigTx(*createMultisigTxFrom, *createMultisigTxTo, *createMultisigTxAmount, *createMultisigTxFee, nodeId)
+	}
+
+	if cosignCmd.Parsed() {
+		if *cosignTx == "" || *cosignFrom == "" || *cosignSigner == "" {
+			cosignCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.cosign(*cosignTx, *cosignFrom, *cosignSigner, nodeId)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxTx == "" || (*sendRawTxMine && *sendRawTxMiner == "") {
+			sendRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.sendRawTx(*sendRawTxTx, nodeId, *sendRawTxMine, *sendRawTxMiner)
+	}
+
+	if initiateSwapCmd.Parsed() {
+		if *initiateSwapFrom == "" || *initiateSwapParticipant == "" || *initiateSwapAmount <= 0 || *initiateSwapFee < 0 ||
+			*initiateSwapLockTime <= 0 || *initiateSwapLockTime > int64(blockchain.MaxSequence) {
+			initiateSwapCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.initiateSwap(*initiateSwapFrom, *initiateSwapParticipant, *initiateSwapLockTime, *initiateSwapAmount, *initiateSwapFee, nodeId, *initiateSwapMine)
+	}
+
+	if participateSwapCmd.Parsed() {
+		if *participateSwapFrom == "" || *participateSwapInitiator == "" || *participateSwapHash == "" || *participateSwapAmount <= 0 ||
+			*participateSwapFee < 0 || *participateSwapLockTime <= 0 || *participateSwapLockTime > int64(blockchain.MaxSequence) {
+			participateSwapCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.participateSwap(*participateSwapFrom, *participateSwapInitiator, *participateSwapHash, *participateSwapLockTime, *participateSwapAmount, *participateSwapFee, nodeId, *participateSwapMine)
+	}
+
+	if auditSwapCmd.Parsed() {
+		if *auditSwapContract == "" {
+			auditSwapCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.auditSwap(*auditSwapContract, nodeId)
+	}
+
+	if redeemSwapCmd.Parsed() {
+		if *redeemSwapContract == "" || *redeemSwapSecret == "" || *redeemSwapTo == "" || *redeemSwapFee < 0 {
+			redeemSwapCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.redeemSwap(*redeemSwapContract, *redeemSwapSecret, *redeemSwapTo, *redeemSwapFee, nodeId, *redeemSwapMine)
+	}
+
+	if refundSwapCmd.Parsed() {
+		if *refundSwapContract == "" || *refundSwapTo == "" || *refundSwapFee < 0 {
+			refundSwapCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.refundSwap(*refundSwapContract, *refundSwapTo, *refundSwapFee, nodeId, *refundSwapMine)
+	}
+
+	if extractSecretCmd.Parsed() {
+		if *extractSecretContract == "" {
+			extractSecretCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.extractSecret(*extractSecretContract, nodeId)
+	}
+
+	if mineCmd.Parsed() {
+		if *mineAddress == "" || *mineBlocks <= 0 {
+			mineCmd.Usage()
+			runtime.Goexit()
+		}
+
+		blockchain.MinerThreads = *mineThreads
+		cli.mine(*mineBlocks, *mineAddress, nodeId)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateAddress == "" || *generateBlocks <= 0 {
+			generateCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.generate(*generateBlocks, *generateAddress, nodeId)
+	}
+
+	if notarizeCmd.Parsed() {
+		// the fee makes sure transaction spends an input and is unique
+		if *notarizeFrom == "" || *notarizeHash == "" || *notarizeFee <= 0 {
+			notarizeCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.notarize(*notarizeFrom, *notarizeHash, *notarizeFee, nodeId, *notarizeMine)
+	}
+
+	if findNotarizationCmd.Parsed() {
+		if *findNotarizationHash == "" {
+			findNotarizationCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.findNotarization(*findNotarizationHash, nodeId)
+	}
+
+	if listUnspentCmd.Parsed() {
+		if *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.listUnspent(*listUnspentAddress, nodeId)
+	}
 
-		if nodeId == "" {
-			startNodeCmd.Usage()
+	if createRawTxCmd.Parsed() {
+		if *createRawTxInputs == "" && *createRawTxOutputs == "" {
+			createRawTxCmd.Usage()
 			runtime.Goexit()
 		}
 
+		cli.createRawTx(*createRawTxTx, *createRawTxInputs, *createRawTxOutputs)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxTx == "" || *signRawTxSigner == "" {
+			signRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+
+		cli.signRawTx(*signRawTxTx, *signRawTxSigner, *signRawTxSigHash, nodeId)
+	}
+
+	if startNodeCmd.Parsed() {
+		blockchain.MinerThreads = *startNodeThreads
 		cli.StartNode(nodeId, *startNodeMiner)
 	}
 }
@@ -0,0 +1,462 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/Dimashey/blockchain/blockchain"
+)
+
+const (
+	// MaxReplacements limits number of transactions evicted from the pool by one replacement,
+	// including descendants of the conflicting transactions
+	MaxReplacements = 100
+	// IncrementalRelayFee is fee per 1000 bytes replacement has to add on top of fees
+	// of evicted transactions to pay for its own relay
+	IncrementalRelayFee = 1
+	// MaxBlockTemplateSize limits size of transactions BlockTemplate selects for a block
+	MaxBlockTemplateSize = 1000000
+)
+
+// Memory pool rejects transactions with these codes besides the consensus ones
+const (
+	RejectAlreadyKnown        blockchain.RejectCode = "txn-already-known"
+	RejectMempoolConflict     blockchain.RejectCode = "txn-mempool-conflict"
+	RejectInsufficientFee     blockchain.RejectCode = "insufficient-fee"
+	RejectTooManyReplacements blockchain.RejectCode = "too-many-replacements"
+)
+
+func rejectf(code blockchain.RejectCode, format string, args ...interface{}) *blockchain.ValidationError {
+	return &blockchain.ValidationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type poolEntry struct {
+	tx   blockchain.Transaction
+	fee  int
+	size int
+}
+
+// Mempool keeps transactions waiting to be mined. Every output is spent by at most one
+// of them, a conflicting transaction is accepted only as replace-by-fee of the ones it conflicts with.
+type Mempool struct {
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+	// spends maps outpoint to ID of pool transaction spending it
+	spends map[string]string
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{
+		entries: make(map[string]*poolEntry),
+		spends:  make(map[string]string),
+	}
+}
+
+func outpoint(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+func (m *Mempool) Get(txID string) (blockchain.Transaction, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[txID]
+
+	if !ok {
+		return blockchain.Transaction{}, false
+	}
+
+	return entry.tx, true
+}
+
+func (m *Mempool) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.entries)
+}
+
+// Add puts transaction into the pool and returns IDs of transactions it replaced.
+// Transaction spending outputs already spent in the pool replaces those transactions
+// and their descendants only if each of them signals replacement, it pays higher fee rate
+// than each of them and higher fee than all of them together by at least IncrementalRelayFee
+func (m *Mempool) Add(tx blockchain.Transaction, chain *blockchain.Chain) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+
+	if _, ok := m.entries[txID]; ok {
+		return nil, rejectf(RejectAlreadyKnown, "transaction %s is already in the pool", txID)
+	}
+
+	if err := blockchain.CheckOutputs(&tx); err != nil {
+		return nil, err
+	}
+
+	// value of output spent twice would be counted twice in the fee
+	spent := make(map[string]bool)
+
+	for _, in := range tx.Inputs {
+		if spent[outpoint(in.ID, in.Out)] {
+			return nil, rejectf(blockchain.RejectDoubleSpend, "transaction %s spends output %s twice", txID, outpoint(in.ID, in.Out))
+		}
+
+		spent[outpoint(in.ID, in.Out)] = true
+	}
+
+	prevOuts, err := m.findOutputs(&tx, chain)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err := blockchain.Fee(&tx, prevOuts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &poolEntry{tx, fee, len(tx.Serialize())}
+
+	if entry.fee < 0 {
+		return nil, rejectf(blockchain.RejectBadValue, "transaction %s spends %d more than it has", txID, -entry.fee)
+	}
+
+	if err := tx.VerifyScripts(prevOuts); err != nil {
+		return nil, rejectf(blockchain.RejectScriptFailed, "transaction %s is not unlocked: %s", txID, err)
+	}
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+	if !UTXOSet.IsMature(&tx, chain.GetBestHeight()+1) {
+		return nil, rejectf(blockchain.RejectImmatureCoinbase, "transaction %s spends immature coinbase", txID)
+	}
+
+	conflicts := make(map[string]bool)
+
+	for _, in := range tx.Inputs {
+		if spender, ok := m.spends[outpoint(in.ID, in.Out)]; ok {
+			conflicts[spender] = true
+		}
+	}
+
+	var replaced []string
+
+	if len(conflicts) > 0 {
+		if replaced, err = m.checkReplacement(entry, conflicts); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range replaced {
+		m.remove(id)
+	}
+
+	m.entries[txID] = entry
+
+	for _, in := range tx.Inputs {
+		m.spends[outpoint(in.ID, in.Out)] = txID
+	}
+
+	return replaced, nil
+}
+
+// checkReplacement checks replace-by-fee rules for entry conflicting
+// with pool transactions and returns IDs of transactions it evicts
+func (m *Mempool) checkReplacement(entry *poolEntry, conflicts map[string]bool) ([]string, error) {
+	txID := hex.EncodeToString(entry.tx.ID)
+	evicted := make(map[string]bool)
+
+	for id := range conflicts {
+		conflict := m.entries[id]
+
+		if !m.signalsReplacement(id) {
+			return nil, rejectf(RejectMempoolConflict, "transaction %s conflicts with %s, which is not replaceable", txID, id)
+		}
+
+		// fee rates are compared as fee/size fractions
+		if entry.fee*conflict.size <= conflict.fee*entry.size {
+			return nil, rejectf(RejectInsufficientFee, "transaction %s does not pay higher fee rate than %s", txID, id)
+		}
+
+		m.collectDescendants(id, evicted)
+	}
+
+	if len(evicted) > MaxReplacements {
+		return nil, rejectf(RejectTooManyReplacements, "transaction %s would replace %d transactions, at most %d allowed", txID, len(evicted), MaxReplacements)
+	}
+
+	evictedFee := 0
+
+	for id := range evicted {
+		evictedFee += m.entries[id].fee
+	}
+
+	for _, in := range entry.tx.Inputs {
+		if evicted[hex.EncodeToString(in.ID)] {
+			return nil, rejectf(RejectMempoolConflict, "transaction %s spends output of transaction it replaces", txID)
+		}
+	}
+
+	if entry.fee <= evictedFee {
+		return nil, rejectf(RejectInsufficientFee, "transaction %s pays %d, replaced transactions pay %d", txID, entry.fee, evictedFee)
+	}
+
+	if (entry.fee-evictedFee)*1000 < IncrementalRelayFee*entry.size {
+		return nil, rejectf(RejectInsufficientFee, "transaction %s does not pay for its relay on top of replaced fee %d", txID, evictedFee)
+	}
+
+	var replaced []string
+
+	for id := range evicted {
+		replaced = append(replaced, id)
+	}
+
+	return replaced, nil
+}
+
+// signalsReplacement reports whether transaction or any of its unconfirmed ancestors signals replacement
+func (m *Mempool) signalsReplacement(txID string) bool {
+	entry, ok := m.entries[txID]
+
+	if !ok {
+		return false
+	}
+
+	if entry.tx.SignalsReplacement() {
+		return true
+	}
+
+	for _, in := range entry.tx.Inputs {
+		if m.signalsReplacement(hex.EncodeToString(in.ID)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collectDescendants adds transaction and pool transactions spending its outputs to set
+func (m *Mempool) collectDescendants(txID string, set map[string]bool) {
+	if set[txID] {
+		return
+	}
+
+	set[txID] = true
+
+	id, _ := hex.DecodeString(txID)
+
+	for out := range m.entries[txID].tx.Outputs {
+		if spender, ok := m.spends[outpoint(id, out)]; ok {
+			m.collectDescendants(spender, set)
+		}
+	}
+}
+
+// findOutputs returns outputs spent by transaction, which are either in the UTXO set
+// or created by pool transactions
+func (m *Mempool) findOutputs(tx *blockchain.Transaction, chain *blockchain.Chain) ([]blockchain.TxOutput, error) {
+	prevOuts := make([]blockchain.TxOutput, len(tx.Inputs))
+	confirmed := blockchain.Transaction{}
+
+	var confirmedIdx []int
+
+	for i, in := range tx.Inputs {
+		parent, ok := m.entries[hex.EncodeToString(in.ID)]
+
+		if !ok {
+			confirmed.Inputs = append(confirmed.Inputs, in)
+			confirmedIdx = append(confirmedIdx, i)
+			continue
+		}
+
+		if in.Out < 0 || in.Out >= len(parent.tx.Outputs) {
+			return nil, rejectf(blockchain.RejectMissingInputs, "output %x:%d does not exist", in.ID, in.Out)
+		}
+
+		prevOuts[i] = parent.tx.Outputs[in.Out]
+	}
+
+	if len(confirmed.Inputs) > 0 {
+		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+		outs, err := UTXOSet.FindOutputs(&confirmed)
+
+		if err != nil {
+			return nil, rejectf(blockchain.RejectMissingInputs, "%s", err)
+		}
+
+		for i, out := range outs {
+			prevOuts[confirmedIdx[i]] = out
+		}
+	}
+
+	return prevOuts, nil
+}
+
+// BlockTemplate selects pool transactions for the next block and returns them with fees they pay.
+// Transaction is selected together with its unconfirmed ancestors as a package and packages
+// with the highest fee rate go first, so child paying high fee gets its parent mined too.
+// Parents always come before their children.
+func (m *Mempool) BlockTemplate(chain *blockchain.Chain) ([]*blockchain.Transaction, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revalidate(chain)
+
+	var txs []*blockchain.Transaction
+
+	fees, size := 0, 0
+	selected := make(map[string]bool)
+	candidates := make(map[string]bool)
+
+	for id := range m.entries {
+		candidates[id] = true
+	}
+
+	for len(candidates) > 0 {
+		var best []string
+
+		bestFee, bestSize := 0, 0
+
+		for id := range candidates {
+			pkg := m.ancestors(id, selected, nil)
+			pkgFee, pkgSize := m.packageFee(pkg)
+
+			// fee rates are compared as fee/size fractions, ties are broken by ID to keep selection stable
+			if best == nil || pkgFee*bestSize > bestFee*pkgSize ||
+				(pkgFee*bestSize == bestFee*pkgSize && id < best[len(best)-1]) {
+				best, bestFee, bestSize = pkg, pkgFee, pkgSize
+			}
+		}
+
+		if size+bestSize > MaxBlockTemplateSize {
+			// descendants of the package don't fit either
+			excluded := make(map[string]bool)
+			m.collectDescendants(best[len(best)-1], excluded)
+
+			for id := range excluded {
+				delete(candidates, id)
+			}
+
+			continue
+		}
+
+		for _, id := range best {
+			tx := m.entries[id].tx
+			txs = append(txs, &tx)
+			selected[id] = true
+			delete(candidates, id)
+		}
+
+		fees += bestFee
+		size += bestSize
+	}
+
+	return txs, fees
+}
+
+// ancestors returns transaction and its pool ancestors which are not in selected, parents first
+func (m *Mempool) ancestors(txID string, selected map[string]bool, pkg []string) []string {
+	if selected[txID] {
+		return pkg
+	}
+
+	for _, id := range pkg {
+		if id == txID {
+			return pkg
+		}
+	}
+
+	for _, in := range m.entries[txID].tx.Inputs {
+		if parent := hex.EncodeToString(in.ID); m.entries[parent] != nil {
+			pkg = m.ancestors(parent, selected, pkg)
+		}
+	}
+
+	return append(pkg, txID)
+}
+
+func (m *Mempool) packageFee(pkg []string) (int, int) {
+	fee, size := 0, 0
+
+	for _, id := range pkg {
+		fee += m.entries[id].fee
+		size += m.entries[id].size
+	}
+
+	return fee, size
+}
+
+// revalidate drops transactions, together with their descendants, which can't be mined
+// on top of the current main chain anymore, because their inputs are spent or locks
+// are not over after the chain is reorganized
+func (m *Mempool) revalidate(chain *blockchain.Chain) {
+	for id, entry := range m.entries {
+		if _, err := m.findOutputs(&entry.tx, chain); err == nil {
+			if err = chain.CheckLockTimes(&entry.tx); err == nil {
+				continue
+			}
+		}
+
+		invalid := make(map[string]bool)
+		m.collectDescendants(id, invalid)
+
+		for invalidID := range invalid {
+			m.remove(invalidID)
+		}
+	}
+}
+
+// Remove drops transaction from the pool
+func (m *Mempool) Remove(txID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(txID)
+}
+
+func (m *Mempool) remove(txID string) {
+	entry, ok := m.entries[txID]
+
+	if !ok {
+		return
+	}
+
+	for _, in := range entry.tx.Inputs {
+		key := outpoint(in.ID, in.Out)
+
+		if m.spends[key] == txID {
+			delete(m.spends, key)
+		}
+	}
+
+	delete(m.entries, txID)
+}
+
+// RemoveBlock drops transactions included in block and pool transactions
+// spending the same outputs together with their descendants
+func (m *Mempool) RemoveBlock(block *blockchain.Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		m.remove(hex.EncodeToString(tx.ID))
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, in := range tx.Inputs {
+			if spender, ok := m.spends[outpoint(in.ID, in.Out)]; ok {
+				conflicted := make(map[string]bool)
+				m.collectDescendants(spender, conflicted)
+
+				for id := range conflicted {
+					m.remove(id)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/Dimashey/blockchain/blockchain"
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// newTestChain creates regtest chain in a temporary directory premining amount to every owner
+func newTestChain(t *testing.T, amount int, owners ...*wallet.Wallet) *blockchain.Chain {
+	params := chaincfg.RegTestParams
+	params.DataDir = t.TempDir()
+
+	previous := chaincfg.Active
+	chaincfg.Active = &params
+
+	genesis := &chaincfg.Genesis{}
+
+	for _, owner := range owners {
+		genesis.Alloc = append(genesis.Alloc, chaincfg.GenesisAlloc{Address: walletAddress(owner), Amount: amount})
+	}
+
+	chain := blockchain.InitBlockChain("mempool", blockchain.ConsensusConfig{Engine: blockchain.PoWEngine}, genesis)
+
+	t.Cleanup(func() {
+		chain.Database.Close()
+		chaincfg.Active = previous
+	})
+
+	return chain
+}
+
+func walletAddress(w *wallet.Wallet) string {
+	return fmt.Sprintf("%s", w.Address())
+}
+
+// spendGenesis returns transaction of owner spending its genesis allocation to outputs
+func spendGenesis(t *testing.T, chain *blockchain.Chain, owner *wallet.Wallet, sequence uint32, outputs ...blockchain.TxOutput) *blockchain.Transaction {
+	genesis, err := chain.GetBlock(chain.GenesisHash())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := genesis.Transactions[0]
+	out := -1
+
+	for i := range coinbase.Outputs {
+		if coinbase.Outputs[i].IsLockedWith(blockchain.LockScript(walletAddress(owner))) {
+			out = i
+		}
+	}
+
+	tx := &blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: coinbase.ID, Out: out, Sequence: sequence}},
+		Outputs: outputs,
+	}
+	tx.ID = tx.Hash()
+	chain.SignTransaction(tx, owner)
+
+	return tx
+}
+
+func rejected(err error, code blockchain.RejectCode) bool {
+	invalid, ok := err.(*blockchain.ValidationError)
+
+	return ok && invalid.Code == code
+}
+
+func TestMempoolRejectsValueInDataOutput(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, 100, owner)
+	data, _ := blockchain.DataScript([]byte("hello"))
+
+	pool := NewMempool()
+	burn := spendGenesis(t, chain, owner, blockchain.MaxSequence, blockchain.TxOutput{Value: 1, Script: data}, *blockchain.NewTXOutput(98, walletAddress(owner)))
+
+	if _, err := pool.Add(*burn, chain); !rejected(err, blockchain.RejectBadDataCarrier) {
+		t.Errorf("transaction sending value to data output is added with error %v, want %s", err, blockchain.RejectBadDataCarrier)
+	}
+
+	record := spendGenesis(t, chain, owner, blockchain.MaxSequence, blockchain.TxOutput{Value: 0, Script: data}, *blockchain.NewTXOutput(99, walletAddress(owner)))
+
+	if _, err := pool.Add(*record, chain); err != nil {
+		t.Errorf("data transaction is rejected: %s", err)
+	}
+}
+
+func TestMempoolRejectsDuplicateInputs(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, 100, owner)
+
+	tx := spendGenesis(t, chain, owner, blockchain.MaxSequence, *blockchain.NewTXOutput(150, walletAddress(owner)))
+	tx.Inputs = append(tx.Inputs, tx.Inputs[0])
+	tx.ID = tx.Hash()
+	chain.SignTransaction(tx, owner)
+
+	pool := NewMempool()
+
+	if _, err := pool.Add(*tx, chain); !rejected(err, blockchain.RejectDoubleSpend) {
+		t.Errorf("transaction spending output twice is added with error %v, want %s", err, blockchain.RejectDoubleSpend)
+	}
+
+	if pool.Count() != 0 {
+		t.Errorf("pool holds %d transactions", pool.Count())
+	}
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	owner, recipient := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, 100, owner)
+	to := walletAddress(wallet.MakeWallet())
+
+	pool := NewMempool()
+	original := spendGenesis(t, chain, owner, blockchain.ReplaceableSequence, *blockchain.NewTXOutput(99, walletAddress(recipient)))
+
+	if _, err := pool.Add(*original, chain); err != nil {
+		t.Fatal(err)
+	}
+
+	// child of the replaced transaction is evicted too
+	child := &blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: original.ID, Out: 0, Sequence: blockchain.MaxSequence}},
+		Outputs: []blockchain.TxOutput{*blockchain.NewTXOutput(98, to)},
+	}
+	child.ID = child.Hash()
+	child.Sign(recipient, map[string]blockchain.Transaction{hex.EncodeToString(original.ID): *original})
+
+	if _, err := pool.Add(*child, chain); err != nil {
+		t.Fatal(err)
+	}
+
+	sameFee := spendGenesis(t, chain, owner, blockchain.ReplaceableSequence, *blockchain.NewTXOutput(99, to))
+
+	if _, err := pool.Add(*sameFee, chain); !rejected(err, RejectInsufficientFee) {
+		t.Fatalf("replacement paying the same fee is added with error %v, want %s", err, RejectInsufficientFee)
+	}
+
+	replacement := spendGenesis(t, chain, owner, blockchain.MaxSequence, *blockchain.NewTXOutput(90, to))
+	replaced, err := pool.Add(*replacement, chain)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replaced) != 2 || pool.Count() != 1 {
+		t.Errorf("replacement evicts %d transactions leaving %d in the pool, want 2 and 1", len(replaced), pool.Count())
+	}
+
+	// replacement does not signal replacement itself
+	other := spendGenesis(t, chain, owner, blockchain.MaxSequence, *blockchain.NewTXOutput(10, to))
+
+	if _, err := pool.Add(*other, chain); !rejected(err, RejectMempoolConflict) {
+		t.Errorf("replacement of final transaction is added with error %v, want %s", err, RejectMempoolConflict)
+	}
+}
+
+// TestBlockTemplateChildPaysForParent selects parent paying low fee ahead of other
+// transactions when its child pays enough for both of them
+func TestBlockTemplateChildPaysForParent(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, 100, alice, bob)
+	to := walletAddress(wallet.MakeWallet())
+
+	pool := NewMempool()
+	parent := spendGenesis(t, chain, alice, blockchain.MaxSequence, *blockchain.NewTXOutput(99, walletAddress(carol)))
+	other := spendGenesis(t, chain, bob, blockchain.MaxSequence, *blockchain.NewTXOutput(95, to))
+
+	for _, tx := range []*blockchain.Transaction{parent, other} {
+		if _, err := pool.Add(*tx, chain); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(txs []*blockchain.Transaction) []string {
+		var ids []string
+
+		for _, tx := range txs {
+			ids = append(ids, hex.EncodeToString(tx.ID))
+		}
+
+		return ids
+	}
+
+	txs, fees := pool.BlockTemplate(chain)
+	want := ids([]*blockchain.Transaction{other, parent})
+
+	if fmt.Sprint(ids(txs)) != fmt.Sprint(want) || fees != 6 {
+		t.Fatalf("block template is %v paying %d, want %v paying 6", ids(txs), fees, want)
+	}
+
+	child := &blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: parent.ID, Out: 0, Sequence: blockchain.MaxSequence}},
+		Outputs: []blockchain.TxOutput{*blockchain.NewTXOutput(79, to)},
+	}
+	child.ID = child.Hash()
+	child.Sign(carol, map[string]blockchain.Transaction{hex.EncodeToString(parent.ID): *parent})
+
+	if _, err := pool.Add(*child, chain); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, fees = pool.BlockTemplate(chain)
+	want = ids([]*blockchain.Transaction{parent, child, other})
+
+	if fmt.Sprint(ids(txs)) != fmt.Sprint(want) || fees != 26 {
+		t.Errorf("block template is %v paying %d, want %v paying 26", ids(txs), fees, want)
+	}
+}
@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
@@ -10,9 +11,13 @@ import (
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Dimashey/blockchain/blockchain"
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
 	"github.com/vrecan/death/v3"
 )
 
@@ -23,11 +28,19 @@ const (
 )
 
 var (
-	nodeAddress     string
-	minerAddress    string
-	KnownNodes      = []string{"localhost:3000"}
+	nodeAddress  string
+	minerAddress string
+	KnownNodes   = append([]string{}, chaincfg.Active.Seeds...)
+	memoryPool   = NewMempool()
+
+	// blocksInTransit are blocks announced by a peer which are not requested yet,
+	// connections are handled concurrently so it is guarded by transitMu
 	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
+	transitMu       sync.Mutex
+
+	// cancelMining aborts block being mined when the main chain tip changes
+	cancelMining context.CancelFunc
+	miningMu     sync.Mutex
 )
 
 type Addr struct {
@@ -55,6 +68,14 @@ type Inv struct {
 	Items    [][]byte
 }
 
+type Reject struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+	Code     string
+	Reason   string
+}
+
 type Tx struct {
 	AddrFrom    string
 	Transaction []byte
@@ -64,11 +85,15 @@ type Version struct {
 	Version   int
 	BestHeigh int
 	AddFrom   string
+	// Timestamp is the sender clock, used to calculate network adjusted time
+	Timestamp int64
+	// GenesisHash identifies the chain of the sender, nodes of other chains are not talked to
+	GenesisHash []byte
 }
 
-func StartServer(nodeId, minerAddress string) {
+func StartServer(nodeId, minerAddr string) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeId)
-	minerAddress = minerAddress
+	minerAddress = minerAddr
 
 	ln, err := net.Listen(protocol, nodeAddress)
 
@@ -83,6 +108,18 @@ func StartServer(nodeId, minerAddress string) {
 
 	go CloseDB(chain)
 
+	// proof-of-authority blocks are sealed with the key of the miner address
+	if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok && len(minerAddress) > 0 {
+		wallets, err := wallet.CreateWallets(nodeId)
+
+		if err != nil {
+			log.Panic(err)
+		}
+
+		signer := wallets.GetWallet(minerAddress)
+		poa.Signer = &signer
+	}
+
 	if nodeAddress != KnownNodes[0] {
 		SendVersion(KnownNodes[0], chain)
 	}
@@ -106,6 +143,15 @@ func HandleConnection(conn net.Conn, chain *blockchain.Chain) {
 		log.Panic(err)
 	}
 
+	magic := chaincfg.Active.Magic
+
+	if len(req) < len(magic)+commandLength || bytes.Compare(req[:len(magic)], magic[:]) != 0 {
+		fmt.Printf("Dropped message of other network from %s\n", conn.RemoteAddr())
+		return
+	}
+
+	req = req[len(magic):]
+
 	command := BytesToCmd(req[:commandLength])
 	fmt.Printf("Received %s command\n", command)
 
@@ -116,10 +162,12 @@ func HandleConnection(conn net.Conn, chain *blockchain.Chain) {
 		HandleBlock(req, chain)
 	case "inv":
 		HandleInv(req, chain)
-	case "getblock":
+	case "getblocks":
 		HandleGetBlocks(req, chain)
 	case "getdata":
 		HandleGetData(req, chain)
+	case "reject":
+		HandleReject(req)
 	case "tx":
 		HandleTx(req, chain)
 	case "version":
@@ -160,24 +208,85 @@ func HandleBlock(request []byte, chain *blockchain.Chain) {
 	}
 
 	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
+	block, err := blockchain.DecodeBlock(blockData)
+
+	if err != nil {
+		fmt.Printf("Received block can not be decoded: %s\n", err)
+		return
+	}
 
 	fmt.Println("Received a new block!")
-	chain.AddBlock(block)
+
+	disconnected, connected, err := chain.ProcessBlock(block)
+
+	if len(connected) > 0 {
+		StopMining()
+		updateMempool(disconnected, connected, chain)
+	}
+
+	if err != nil {
+		fmt.Printf("Block %x is rejected: %s\n", block.Hash, err)
+
+		if invalid, ok := err.(*blockchain.ValidationError); ok {
+			SendReject(payload.AddrFrom, "block", block.Hash, invalid)
+		}
+
+		return
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
+	if len(block.PrevHash) != 0 && !chain.HasBlock(block.PrevHash) {
+		SendGetBlocks(payload.AddrFrom)
+	}
+
+	transitMu.Lock()
+	defer transitMu.Unlock()
+
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		SendGetData(payload.AddrFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
 	}
 }
 
+// updateMempool returns transactions of blocks which left the main chain to the pool,
+// oldest first so parents come before their children, and drops those the new
+// main chain has mined or spent
+func updateMempool(disconnected, connected []*blockchain.Block, chain *blockchain.Chain) {
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+
+			if _, err := memoryPool.Add(*tx, chain); err != nil {
+				fmt.Printf("Transaction %x of disconnected block is dropped: %s\n", tx.ID, err)
+			}
+		}
+	}
+
+	for _, block := range connected {
+		memoryPool.RemoveBlock(block)
+	}
+}
+
+func HandleReject(request []byte) {
+	var buff bytes.Buffer
+	var payload Reject
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("%s rejected %s %x: %s (%s)\n", payload.AddrFrom, payload.Type, payload.ID, payload.Code, payload.Reason)
+}
+
 func HandleGetBlocks(request []byte, chain *blockchain.Chain) {
 	var buff bytes.Buffer
 	var payload GetBlocks
@@ -217,8 +326,11 @@ func HandleGetData(request []byte, chain *blockchain.Chain) {
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
+		tx, ok := memoryPool.Get(hex.EncodeToString(payload.ID))
+
+		if !ok {
+			return
+		}
 
 		SendTx(payload.AddrFrom, &tx)
 	}
@@ -236,6 +348,16 @@ func HandleVersion(request []byte, chain *blockchain.Chain) {
 		log.Panic(err)
 	}
 
+	if genesis := chain.GenesisHash(); bytes.Compare(payload.GenesisHash, genesis) != 0 {
+		fmt.Printf("Peer %s is on another chain with genesis block %x\n", payload.AddFrom, payload.GenesisHash)
+		forgetNode(payload.AddFrom)
+		SendReject(payload.AddFrom, "version", payload.GenesisHash, rejectf(blockchain.RejectWrongGenesis, "genesis block is %x, expected %x", payload.GenesisHash, genesis))
+
+		return
+	}
+
+	chain.Clock.AddTimeSample(payload.AddFrom, payload.Timestamp)
+
 	bestHeight := chain.GetBestHeight()
 	otherHeigth := payload.BestHeigh
 
@@ -264,10 +386,36 @@ func HandleTx(request []byte, chain *blockchain.Chain) {
 	}
 
 	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := blockchain.DecodeTransaction(txData)
+
+	if err != nil {
+		fmt.Printf("Received transaction can not be decoded: %s\n", err)
+		return
+	}
+
+	// transactions which can't be mined in the next block are not relayed
+	if err := chain.CheckLockTimes(&tx); err != nil {
+		fmt.Printf("Transaction %x is rejected: %s\n", tx.ID, err)
+		return
+	}
+
+	replaced, err := memoryPool.Add(tx, chain)
+
+	if err != nil {
+		fmt.Printf("Transaction %x is rejected: %s\n", tx.ID, err)
 
-	fmt.Printf("%s", "%d", nodeAddress, len(memoryPool))
+		if invalid, ok := err.(*blockchain.ValidationError); ok {
+			SendReject(payload.AddrFrom, "tx", tx.ID, invalid)
+		}
+
+		return
+	}
+
+	for _, id := range replaced {
+		fmt.Printf("Transaction %s is replaced by %x\n", id, tx.ID)
+	}
+
+	fmt.Printf("%s, %d\n", nodeAddress, memoryPool.Count())
 
 	if nodeAddress == KnownNodes[0] {
 		for _, node := range KnownNodes {
@@ -276,22 +424,17 @@ func HandleTx(request []byte, chain *blockchain.Chain) {
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(minerAddress) > 0 {
+		if memoryPool.Count() >= 2 && len(minerAddress) > 0 {
 			MineTx(chain)
 		}
 	}
 }
 
 func MineTx(chain *blockchain.Chain) {
-	var txs []*blockchain.Transaction
+	txs, fees := memoryPool.BlockTemplate(chain)
 
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
+	for _, tx := range txs {
+		fmt.Printf("tx: %x\n", tx.ID)
 	}
 
 	if len(txs) == 0 {
@@ -299,27 +442,38 @@ func MineTx(chain *blockchain.Chain) {
 		return
 	}
 
-	cbTx := blockchain.CoinbaseTx(minerAddress, "")
-	txs = append(txs, cbTx)
+	cbTx := blockchain.CoinbaseTx(minerAddress, "", chain.GetBestHeight()+1, fees)
+	txs = append([]*blockchain.Transaction{cbTx}, txs...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	miningMu.Lock()
+	cancelMining = cancel
+	miningMu.Unlock()
+
+	newBlock, err := chain.MineBlock(ctx, txs)
+
+	if err != nil {
+		fmt.Printf("Mining is aborted: %s\n", err)
+		return
+	}
 
 	fmt.Println("New Block is mined")
 
-	for _, tx := range txs {
-		txId := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txId)
+	if pow, ok := chain.Engine.(*blockchain.ProofOfWorkEngine); ok {
+		fmt.Printf("Hash rate: %.0f H/s with %d threads\n", pow.HashRate(), blockchain.MinerThreads)
 	}
 
+	memoryPool.RemoveBlock(newBlock)
+
 	for _, node := range KnownNodes {
 		if node != nodeAddress {
 			SendInv(node, "block", [][]byte{newBlock.Hash})
 		}
 	}
 
-	if len(memoryPool) > 0 {
+	if memoryPool.Count() > 0 {
 		MineTx(chain)
 	}
 }
@@ -339,9 +493,22 @@ func HandleInv(request []byte, chain *blockchain.Chain) {
 	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		transitMu.Lock()
+		defer transitMu.Unlock()
 
-		blockHash := payload.Items[0]
+		blocksInTransit = [][]byte{}
+
+		for _, b := range payload.Items {
+			if !chain.HasBlock(b) {
+				blocksInTransit = append(blocksInTransit, b)
+			}
+		}
+
+		if len(blocksInTransit) == 0 {
+			return
+		}
+
+		blockHash := blocksInTransit[0]
 		SendGetData(payload.AddrFrom, "block", blockHash)
 
 		newInTransit := [][]byte{}
@@ -358,12 +525,23 @@ func HandleInv(request []byte, chain *blockchain.Chain) {
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
+		if _, ok := memoryPool.Get(hex.EncodeToString(txID)); !ok {
 			SendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
 }
 
+// StopMining aborts mining of a block which is not on top of the main chain anymore
+func StopMining() {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+
+	if cancelMining != nil {
+		cancelMining()
+		cancelMining = nil
+	}
+}
+
 func NodeIsKnown(addr string) bool {
 	for _, node := range KnownNodes {
 		if node == addr {
@@ -374,6 +552,19 @@ func NodeIsKnown(addr string) bool {
 	return false
 }
 
+// forgetNode removes addr from known nodes
+func forgetNode(addr string) {
+	var updatedNodes []string
+
+	for _, node := range KnownNodes {
+		if node != addr {
+			updatedNodes = append(updatedNodes, node)
+		}
+	}
+
+	KnownNodes = updatedNodes
+}
+
 func RequestBlocks() {
 	for _, node := range KnownNodes {
 		SendGetBlocks(node)
@@ -410,6 +601,14 @@ func SendInv(address, kind string, items [][]byte) {
 	SendData(address, request)
 }
 
+func SendReject(address, kind string, id []byte, reason *blockchain.ValidationError) {
+	data := Reject{nodeAddress, kind, id, string(reason.Code), reason.Message}
+	payload := GobEncode(data)
+	request := append(CmdToBytes("reject"), payload...)
+
+	SendData(address, request)
+}
+
 func SendTx(address string, tnx *blockchain.Transaction) {
 	data := Tx{nodeAddress, tnx.Serialize()}
 	payload := GobEncode(data)
@@ -420,7 +619,7 @@ func SendTx(address string, tnx *blockchain.Transaction) {
 
 func SendVersion(address string, chain *blockchain.Chain) {
 	bestHeight := chain.GetBestHeight()
-	payload := GobEncode(Version{version, bestHeight, nodeAddress})
+	payload := GobEncode(Version{version, bestHeight, nodeAddress, time.Now().Unix(), chain.GenesisHash()})
 
 	request := append(CmdToBytes("version"), payload...)
 
@@ -441,28 +640,22 @@ func SendGetData(address, kind string, id []byte) {
 	SendData(address, request)
 }
 
+// SendData sends request prefixed with magic of the active network
 func SendData(addr string, data []byte) {
 	conn, err := net.Dial(protocol, addr)
 
 	if err != nil {
 		fmt.Printf("%s is not available\n", addr)
-
-		var updatedNodes []string
-
-		for _, node := range KnownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-
-		KnownNodes = updatedNodes
+		forgetNode(addr)
 
 		return
 	}
 
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	magic := chaincfg.Active.Magic
+
+	_, err = io.Copy(conn, io.MultiReader(bytes.NewReader(magic[:]), bytes.NewReader(data)))
 
 	if err != nil {
 		log.Panic(err)
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dimashey/blockchain/blockchain"
+	"github.com/Dimashey/blockchain/chaincfg"
+	"github.com/Dimashey/blockchain/wallet"
+)
+
+// TestDropOtherNetworkMessage sends request of the test network to regtest node,
+// which would fail handling it without a chain if it was not dropped
+func TestDropOtherNetworkMessage(t *testing.T) {
+	previous := chaincfg.Active
+	chaincfg.Active = &chaincfg.RegTestParams
+	t.Cleanup(func() { chaincfg.Active = previous })
+
+	client, server := net.Pipe()
+	magic := chaincfg.TestNetParams.Magic
+	request := append(append(magic[:], CmdToBytes("getblocks")...), GobEncode(GetBlocks{AddrFrom: "localhost:13001"})...)
+
+	go func() {
+		client.Write(request)
+		client.Close()
+	}()
+
+	HandleConnection(server, nil)
+}
+
+// TestHandleVersionOtherGenesis rejects peer whose chain starts with another genesis block
+func TestHandleVersionOtherGenesis(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain := newTestChain(t, 100, owner)
+	other := blockchain.InitBlockChain("other", blockchain.ConsensusConfig{Engine: blockchain.PoWEngine}, nil)
+	defer other.Database.Close()
+
+	peer, err := net.Listen(protocol, "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer peer.Close()
+
+	previousNodes := KnownNodes
+	KnownNodes = []string{peer.Addr().String()}
+	t.Cleanup(func() { KnownNodes = previousNodes })
+
+	version := func(genesisHash []byte) []byte {
+		payload := GobEncode(Version{version, chain.GetBestHeight(), peer.Addr().String(), time.Now().Unix(), genesisHash})
+
+		return append(CmdToBytes("version"), payload...)
+	}
+
+	go HandleVersion(version(other.GenesisHash()), chain)
+
+	conn, err := peer.Accept()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	message, err := io.ReadAll(conn)
+	conn.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reject Reject
+	message = message[len(chaincfg.Active.Magic)+commandLength:]
+
+	if err := gob.NewDecoder(bytes.NewReader(message)).Decode(&reject); err != nil {
+		t.Fatal(err)
+	}
+
+	if reject.Code != string(blockchain.RejectWrongGenesis) || NodeIsKnown(peer.Addr().String()) {
+		t.Errorf("peer of other chain is rejected with %s and known: %t", reject.Code, NodeIsKnown(peer.Addr().String()))
+	}
+
+	// peer of the same chain at the same height is remembered and not sent anything
+	HandleVersion(version(chain.GenesisHash()), chain)
+
+	if !NodeIsKnown(peer.Addr().String()) {
+		t.Error("peer of the same chain is not known")
+	}
+}
@@ -3,27 +3,171 @@ package wallet
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
 
+	"github.com/Dimashey/blockchain/chaincfg"
 	"github.com/Dimashey/blockchain/internal/util"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const checksumLength = 4
-const version = byte(0x00)
 
+// PublicKeyLength is length of P256 public key, X and Y of the point are 32 bytes each
+const PublicKeyLength = 64
+
+// Ed25519PublicKeyLength is length of Ed25519 public key, keys of both types are told apart by length
+const Ed25519PublicKeyLength = ed25519.PublicKeySize
+
+// KeyType is signature scheme of wallet key
+type KeyType byte
+
+const (
+	// P256 keys sign with ECDSA over NIST P-256
+	P256 KeyType = iota
+	// Ed25519 keys sign with EdDSA over Curve25519
+	Ed25519
+)
+
+func (t KeyType) String() string {
+	switch t {
+	case P256:
+		return "p256"
+	case Ed25519:
+		return "ed25519"
+	}
+
+	return fmt.Sprintf("KeyType(%d)", byte(t))
+}
+
+// ParseKeyType returns key type of its name
+func ParseKeyType(name string) (KeyType, error) {
+	for _, t := range []KeyType{P256, Ed25519} {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown key type %s", name)
+}
+
+// AddressVersion returns the first byte of addresses of keys of type t on the active network
+func (t KeyType) AddressVersion() byte {
+	if t == Ed25519 {
+		return chaincfg.Active.Ed25519PubKeyHashVersion
+	}
+
+	return chaincfg.Active.PubKeyHashVersion
+}
+
+// PublicKeyType returns type of public key by its length
+func PublicKeyType(pubKey []byte) (KeyType, bool) {
+	switch len(pubKey) {
+	case PublicKeyLength:
+		return P256, true
+	case Ed25519PublicKeyLength:
+		return Ed25519, true
+	}
+
+	return 0, false
+}
+
+// IsKeyAddressVersion returns whether version is of address of a single key of any type
+// on the active network
+func IsKeyAddressVersion(version byte) bool {
+	return version == P256.AddressVersion() || version == Ed25519.AddressVersion()
+}
+
+// Wallet holds key of either type, PrivateKey of P256 wallets
+// or EdPrivateKey of Ed25519 wallets
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
-	PublicKey  []byte
+	PrivateKey   ecdsa.PrivateKey
+	EdPrivateKey ed25519.PrivateKey
+	PublicKey    []byte
+}
+
+// walletData is how wallet is stored, private key is kept as its scalar only,
+// because gob can't encode the curve of ecdsa key. D is the seed of Ed25519 keys
+type walletData struct {
+	D         []byte
+	PublicKey []byte
+	Type      KeyType
+}
+
+func (w Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+
+	stored := walletData{PublicKey: w.PublicKey, Type: w.Type()}
+
+	if stored.Type == Ed25519 {
+		stored.D = w.EdPrivateKey.Seed()
+	} else {
+		stored.D = w.PrivateKey.D.Bytes()
+	}
+
+	err := gob.NewEncoder(&content).Encode(stored)
+
+	return content.Bytes(), err
+}
+
+func (w *Wallet) GobDecode(data []byte) error {
+	var stored walletData
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stored); err != nil {
+		return err
+	}
+
+	// wallets stored before key types were added have no type, they are P256
+	if stored.Type == Ed25519 {
+		if len(stored.D) != ed25519.SeedSize {
+			return errors.New("ed25519 wallet seed is malformed")
+		}
+
+		w.EdPrivateKey = ed25519.NewKeyFromSeed(stored.D)
+		w.PublicKey = []byte(w.EdPrivateKey.Public().(ed25519.PublicKey))
+
+		return nil
+	}
+
+	curve := elliptic.P256()
+
+	w.PrivateKey.Curve = curve
+	w.PrivateKey.D = new(big.Int).SetBytes(stored.D)
+	w.PrivateKey.X, w.PrivateKey.Y = curve.ScalarBaseMult(stored.D)
+	// keys stored before they were padded are fixed up
+	w.PublicKey = PublicKeyBytes(&w.PrivateKey.PublicKey)
+
+	return nil
+}
+
+// Type returns type of the wallet key
+func (w Wallet) Type() KeyType {
+	if w.EdPrivateKey != nil {
+		return Ed25519
+	}
+
+	return P256
 }
 
 func (w Wallet) Address() []byte {
-	pubHash := PublicHash(w.PublicKey)
+	return EncodeAddress(w.Type().AddressVersion(), PublicHash(w.PublicKey))
+}
+
+// ScriptAddress returns address of outputs locked to script hash of script
+func ScriptAddress(script []byte) []byte {
+	return EncodeAddress(chaincfg.Active.ScriptHashVersion, PublicHash(script))
+}
 
-	versionedHash := append([]byte{version}, pubHash...)
+// EncodeAddress returns base58 of version, hash and checksum of both
+func EncodeAddress(version byte, hash []byte) []byte {
+	versionedHash := append([]byte{version}, hash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
@@ -32,6 +176,23 @@ func (w Wallet) Address() []byte {
 	return address
 }
 
+// DecodeAddress returns version and hash of address checked with ValidateAddress
+func DecodeAddress(address string) (byte, []byte) {
+	fullHash := util.Base58Decode([]byte(address))
+
+	return fullHash[0], fullHash[1 : len(fullHash)-checksumLength]
+}
+
+// PublicKeyBytes returns X and Y of public key padded to 32 bytes each,
+// so the key can be split in halves
+func PublicKeyBytes(key *ecdsa.PublicKey) []byte {
+	pub := make([]byte, PublicKeyLength)
+	key.X.FillBytes(pub[:PublicKeyLength/2])
+	key.Y.FillBytes(pub[PublicKeyLength/2:])
+
+	return pub
+}
+
 func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	curve := elliptic.P256()
 
@@ -41,18 +202,38 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 		log.Panic(err)
 	}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	return *private, PublicKeyBytes(&private.PublicKey)
+}
 
-	return *private, pub
+// NewEd25519KeyPair returns Ed25519 private key and its 32 bytes public key
+func NewEd25519KeyPair() (ed25519.PrivateKey, []byte) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return private, public
 }
 
 func MakeWallet() *Wallet {
 	private, public := NewKeyPair()
-	wallet := Wallet{private, public}
+	wallet := Wallet{PrivateKey: private, PublicKey: public}
 
 	return &wallet
 }
 
+// MakeWalletOfType returns wallet with new key of type keyType
+func MakeWalletOfType(keyType KeyType) *Wallet {
+	if keyType != Ed25519 {
+		return MakeWallet()
+	}
+
+	private, public := NewEd25519KeyPair()
+
+	return &Wallet{EdPrivateKey: private, PublicKey: public}
+}
+
 func PublicHash(pubKey []byte) []byte {
 	pubHash := sha256.Sum256(pubKey)
 
@@ -78,6 +259,16 @@ func Checksum(payload []byte) []byte {
 
 func ValidateAddress(address string) bool {
 	pubKeyHash := util.Base58Decode([]byte(address))
+
+	if len(pubKeyHash) <= checksumLength {
+		return false
+	}
+
+	// addresses of other networks are not valid
+	if !IsKeyAddressVersion(pubKeyHash[0]) && pubKeyHash[0] != chaincfg.Active.ScriptHashVersion {
+		return false
+	}
+
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 
 	version := pubKeyHash[0]
@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/Dimashey/blockchain/chaincfg"
+)
+
+// useRegTest makes regtest active for the test, wallet files are kept in a temporary directory
+func useRegTest(t *testing.T) {
+	params := chaincfg.RegTestParams
+	params.DataDir = t.TempDir()
+
+	previous := chaincfg.Active
+	chaincfg.Active = &params
+
+	t.Cleanup(func() { chaincfg.Active = previous })
+}
+
+func TestKeyTypes(t *testing.T) {
+	useRegTest(t)
+
+	versions := map[KeyType]byte{
+		P256:    chaincfg.Active.PubKeyHashVersion,
+		Ed25519: chaincfg.Active.Ed25519PubKeyHashVersion,
+	}
+
+	if versions[P256] == versions[Ed25519] {
+		t.Fatalf("both key types have address version %02x", versions[P256])
+	}
+
+	for keyType, version := range versions {
+		w := MakeWalletOfType(keyType)
+
+		if w.Type() != keyType {
+			t.Errorf("%s wallet has type %s", keyType, w.Type())
+		}
+
+		if got, ok := PublicKeyType(w.PublicKey); !ok || got != keyType {
+			t.Errorf("%s public key has type %s", keyType, got)
+		}
+
+		if parsed, err := ParseKeyType(keyType.String()); err != nil || parsed != keyType {
+			t.Errorf("%s is parsed as %s with error %v", keyType, parsed, err)
+		}
+
+		address := fmt.Sprintf("%s", w.Address())
+		addressVersion, hash := DecodeAddress(address)
+
+		if !ValidateAddress(address) || addressVersion != version || bytes.Compare(hash, PublicHash(w.PublicKey)) != 0 {
+			t.Errorf("%s address %s has version %02x, want %02x", keyType, address, addressVersion, version)
+		}
+	}
+
+	if _, err := ParseKeyType("rsa"); err == nil {
+		t.Error("unknown key type is parsed")
+	}
+}
+
+func TestWalletFile(t *testing.T) {
+	useRegTest(t)
+
+	wallets, _ := CreateWallets("test")
+	addresses := map[string]KeyType{
+		wallets.AddWallet(P256):    P256,
+		wallets.AddWallet(Ed25519): Ed25519,
+	}
+
+	wallets.SaveFile("test")
+
+	loaded, err := CreateWallets("test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for address, keyType := range addresses {
+		w, ok := loaded.Wallets[address]
+
+		if !ok {
+			t.Fatalf("%s wallet %s is not loaded", keyType, address)
+		}
+
+		if w.Type() != keyType || bytes.Compare(w.PublicKey, wallets.Wallets[address].PublicKey) != 0 {
+			t.Errorf("%s wallet is loaded as %s with key %x", keyType, w.Type(), w.PublicKey)
+		}
+
+		if fmt.Sprintf("%s", w.Address()) != address {
+			t.Errorf("%s wallet %s is loaded with address %s", keyType, address, w.Address())
+		}
+	}
+}
+
+func TestValidateAddressOfOtherNetwork(t *testing.T) {
+	useRegTest(t)
+
+	regtestAddress := fmt.Sprintf("%s", MakeWallet().Address())
+
+	if !ValidateAddress(regtestAddress) {
+		t.Fatalf("address %s is not valid", regtestAddress)
+	}
+
+	chaincfg.Active = &chaincfg.MainNetParams
+
+	if ValidateAddress(regtestAddress) {
+		t.Errorf("regtest address %s is valid on %s", regtestAddress, chaincfg.Active.Name)
+	}
+
+	if mainnetAddress := fmt.Sprintf("%s", MakeWalletOfType(Ed25519).Address()); !ValidateAddress(mainnetAddress) {
+		t.Errorf("address %s is not valid", mainnetAddress)
+	}
+}
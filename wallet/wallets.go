@@ -2,24 +2,29 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+
+	"github.com/Dimashey/blockchain/chaincfg"
 )
 
-const walletFile = "./tmp/wallets_%s.data"
+// walletFile returns path of node wallet file in data directory of the active network
+func walletFile(nodeId string) string {
+	return filepath.Join(chaincfg.Active.DataDir, fmt.Sprintf("wallets_%s.data", nodeId))
+}
 
 type Wallets struct {
 	Wallets map[string]*Wallet
+	// Scripts are scripts of script addresses the wallet takes part in
+	Scripts map[string][]byte
 }
 
 func (ws *Wallets) SaveFile(nodeId string) {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeId)
-
-	gob.Register(elliptic.P256())
+	walletFile := walletFile(nodeId)
 
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
@@ -28,6 +33,12 @@ func (ws *Wallets) SaveFile(nodeId string) {
 		log.Panic(err)
 	}
 
+	err = os.MkdirAll(filepath.Dir(walletFile), 0755)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
 	err = os.WriteFile(walletFile, content.Bytes(), 0644)
 
 	if err != nil {
@@ -38,6 +49,7 @@ func (ws *Wallets) SaveFile(nodeId string) {
 func CreateWallets(nodeId string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Scripts = make(map[string][]byte)
 
 	err := wallets.LoadFile(nodeId)
 
@@ -58,8 +70,9 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+// AddWallet creates wallet with new key of type keyType and returns its address
+func (ws *Wallets) AddWallet(keyType KeyType) string {
+	wallet := MakeWalletOfType(keyType)
 	address := fmt.Sprintf("%s", wallet.Address())
 
 	ws.Wallets[address] = wallet
@@ -67,8 +80,34 @@ func (ws *Wallets) AddWallet() string {
 	return address
 }
 
+// GetKeyAddress returns address of the wallet with public key hash pubKeyHash
+func (ws Wallets) GetKeyAddress(pubKeyHash []byte) (string, bool) {
+	for address, wallet := range ws.Wallets {
+		if bytes.Compare(PublicHash(wallet.PublicKey), pubKeyHash) == 0 {
+			return address, true
+		}
+	}
+
+	return "", false
+}
+
+// AddScript remembers script and returns its address
+func (ws *Wallets) AddScript(script []byte) string {
+	address := fmt.Sprintf("%s", ScriptAddress(script))
+
+	ws.Scripts[address] = script
+
+	return address
+}
+
+func (ws Wallets) GetScript(address string) ([]byte, bool) {
+	script, ok := ws.Scripts[address]
+
+	return script, ok
+}
+
 func (ws *Wallets) LoadFile(nodeId string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeId)
+	walletFile := walletFile(nodeId)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -81,11 +120,21 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 		return err
 	}
 
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 
-	ws.Wallets = wallets.Wallets
+	if err != nil {
+		return err
+	}
+
+	// gob leaves empty maps out
+	if wallets.Wallets != nil {
+		ws.Wallets = wallets.Wallets
+	}
+
+	if wallets.Scripts != nil {
+		ws.Scripts = wallets.Scripts
+	}
 
 	return nil
 }